@@ -0,0 +1,28 @@
+package traceUtils
+
+import "strings"
+
+// CallerOutsidePackage - returns the innermost frame of the caller's stack whose package import path doesn't
+// start with pkgPrefix, answering "who called my library" however deep inside it the call is made. Runtime frames
+// never count as the caller. The frame's func and file are formatted according to opts, ok is false when the
+// whole stack is within the package.
+func CallerOutsidePackage(pkgPrefix string, opts ...StackTraceOption) (frame Frame, ok bool) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	walkFrames(1+cfg.SkipFrames, func(f Frame) bool {
+		pkg := funcPackage(f.Func)
+		if strings.HasPrefix(pkg, pkgPrefix) || pkg == "runtime" {
+			return true
+		}
+		frame, ok = f, true
+		return false
+	})
+	if ok {
+		frame.Func = string(resolveFuncName(displayFunc(cfg, frame.Func), cfg.ShortFuncNames))
+		frame.File = displayPath(cfg, frame.File)
+	}
+	return frame, ok
+}
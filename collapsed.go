@@ -0,0 +1,21 @@
+package traceUtils
+
+import "strings"
+
+// CollapsedLine - returns the stack as a single flamegraph collapsed line, "main;handler;parse", outermost first and
+// innermost last as flamegraph tooling expects. Aggregating lines from many crashes shows their hotspots.
+// Tokens follow the short-name setting, source and locations are left out.
+func CollapsedLine(opts ...StackTraceOption) string {
+	t := newTrace(1, opts)
+	tokens := make([]string, len(t.frames))
+	for i, f := range t.frames {
+		tokens[i] = string(resolveFuncName(displayFunc(t.cfg, f.Func), t.cfg.ShortFuncNames))
+	}
+	// frames are captured innermost first unless reversed already
+	if !t.cfg.ReverseOrder {
+		for i, j := 0, len(tokens)-1; i < j; i, j = i+1, j-1 {
+			tokens[i], tokens[j] = tokens[j], tokens[i]
+		}
+	}
+	return strings.Join(tokens, ";")
+}
@@ -0,0 +1,42 @@
+package traceUtils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+)
+
+// NewStackTraceCompressed - returns the output of NewStackTrace gzipped (RFC 1952) and encoded as standard,
+// padded base64 (RFC 4648), for carrying a full trace through size-constrained fields such as headers or span
+// attributes. Any language can expand it by base64-decoding and gunzipping, or with DecompressTrace.
+func NewStackTraceCompressed(opts ...StackTraceOption) (string, error) {
+	trace := newStackTrace(1, opts)
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := zw.Write(trace); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecompressTrace - returns the trace encoded by NewStackTraceCompressed.
+func DecompressTrace(s string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,48 @@
+package traceUtils
+
+import "strconv"
+
+// estimatedSourceLineLen is the assumed length of a source line, source files are never read to estimate.
+const estimatedSourceLineLen = 40
+
+// EstimateSize - returns an estimate of how many bytes NewStackTrace would return for the same opts,
+// e.g. to skip logging a trace that would be too big. It walks the stack but never reads source files,
+// so the result is an approximation, not the exact size.
+func EstimateSize(opts ...StackTraceOption) int {
+	t := newTrace(1, opts)
+	cfg := t.cfg
+
+	size := 0
+	for _, line := range t.header {
+		size += len(line) + len(cfg.FrameSeparator)
+	}
+	for i, f := range t.frames {
+		if i > 0 {
+			size += len(cfg.FrameSeparator)
+		}
+		size += len(displayPath(cfg, f.File))
+		if cfg.ShowLineNumbers {
+			size += 1 + len(strconv.Itoa(f.Line))
+		}
+		if cfg.IncludePC {
+			size += 3 + len(formatPC(f.PC, cfg.PCFormat)) // " (" + ")"
+		}
+		size += len(cfg.ChunkSeparator) + len(cfg.ChunkIndentation) + len(resolveFuncName(displayFunc(cfg, f.Func), cfg.ShortFuncNames))
+		if cfg.IncludeSourceCode {
+			if before, after := cfg.sourceContextLines(); before > 0 || after > 0 {
+				// ":" plus every context line with its separator, indentation, marker and line number
+				lines := before + after + 1
+				size += 1 + lines*(len(cfg.ChunkSeparator)+len(cfg.ChunkIndentation)+6+estimatedSourceLineLen)
+			} else {
+				size += 2 + estimatedSourceLineLen // ": " + code
+			}
+		}
+	}
+	return size
+}
+
+// Depth - returns the number of frames NewStackTrace would render for the same opts, after skipping and limiting,
+// e.g. for metrics on crash depth. Unlike EstimateSize it is exact, source files are never read.
+func Depth(opts ...StackTraceOption) int {
+	return len(newTrace(1, opts).frames)
+}
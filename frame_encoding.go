@@ -0,0 +1,150 @@
+package traceUtils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrTruncatedFrames is returned by DecodeFrames when the input ends in the middle of a frame.
+var ErrTruncatedFrames = errors.New("traceUtils: truncated frame encoding")
+
+// EncodeFrames - returns a compact binary encoding of frames, suitable for batching traces to disk.
+// The format is a uvarint frame count followed by, per frame: uvarint PC, length-prefixed Func,
+// length-prefixed File, varint Line and a flags byte (bit 0 = Inlined).
+func EncodeFrames(frames []Frame) []byte {
+	buf := make([]byte, 0, 16+len(frames)*64)
+	buf = binary.AppendUvarint(buf, uint64(len(frames)))
+	for _, f := range frames {
+		buf = binary.AppendUvarint(buf, uint64(f.PC))
+		buf = appendString(buf, f.Func)
+		buf = appendString(buf, f.File)
+		buf = binary.AppendVarint(buf, int64(f.Line))
+		var flags byte
+		if f.Inlined {
+			flags |= 1
+		}
+		buf = append(buf, flags)
+	}
+	return buf
+}
+
+// DecodeFrames - decodes frames produced by EncodeFrames.
+func DecodeFrames(data []byte) ([]Frame, error) {
+	d := frameDecoder{data: data}
+	count := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	// every frame takes at least 5 bytes, don't let a corrupt count allocate unbounded memory
+	if count > uint64(len(d.data))/5 {
+		return nil, ErrTruncatedFrames
+	}
+
+	frames := make([]Frame, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var f Frame
+		f.PC = uintptr(d.uvarint())
+		f.Func = d.string()
+		f.File = d.string()
+		f.Line = int(d.varint())
+		flags := d.byte()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if flags&^1 != 0 {
+			return nil, fmt.Errorf("traceUtils: invalid frame flags 0x%x", flags)
+		}
+		f.Inlined = flags&1 != 0
+		frames = append(frames, f)
+	}
+	if len(d.data) > 0 {
+		return nil, fmt.Errorf("traceUtils: %d trailing bytes after frames", len(d.data))
+	}
+	return frames, nil
+}
+
+// GoLiteral - returns frames as Go source, `[]Frame{{Func: "main.main", File: "/app/main.go", Line: 12}}`,
+// ready to paste into a test as a golden fixture. PCs are left out since they change with every build.
+func GoLiteral(frames []Frame) string {
+	var out strings.Builder
+	out.WriteString("[]Frame{")
+	for i, f := range frames {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString("{Func: " + strconv.Quote(f.Func) + ", File: " + strconv.Quote(f.File) + ", Line: " + strconv.Itoa(f.Line))
+		if f.Inlined {
+			out.WriteString(", Inlined: true")
+		}
+		out.WriteString("}")
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// frameDecoder consumes data, the first error sticks and makes every later read a no-op.
+type frameDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *frameDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = ErrTruncatedFrames
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *frameDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = ErrTruncatedFrames
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *frameDecoder) string() string {
+	l := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if l > uint64(len(d.data)) {
+		d.err = ErrTruncatedFrames
+		return ""
+	}
+	s := string(d.data[:l])
+	d.data = d.data[l:]
+	return s
+}
+
+func (d *frameDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < 1 {
+		d.err = ErrTruncatedFrames
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
@@ -0,0 +1,59 @@
+package traceUtils_test
+
+import (
+	"reflect"
+	"testing"
+
+	traceUtils "github.com/karsto/common"
+)
+
+var encodingFixture = []traceUtils.Frame{
+	{PC: 0x4a2f10, Func: "main.main", File: "/app/main.go", Line: 12},
+	{PC: 0x4a2f10, Func: "main.handler.func1", File: "/app/handler.go", Line: 48, Inlined: true},
+	{Func: "", File: "", Line: -1},
+	{PC: ^uintptr(0), Func: "github.com/x/y.(*T[...]).M", File: "C:/src/ü.go", Line: 1 << 30},
+}
+
+func TestEncodeFramesRoundTrip(t *testing.T) {
+	for _, frames := range [][]traceUtils.Frame{nil, encodingFixture} {
+		decoded, err := traceUtils.DecodeFrames(traceUtils.EncodeFrames(frames))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frames) == 0 && len(decoded) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(decoded, frames) {
+			t.Fatalf("round trip changed frames:\n%#v\n%#v", frames, decoded)
+		}
+	}
+}
+
+func TestDecodeFramesTruncated(t *testing.T) {
+	data := traceUtils.EncodeFrames(encodingFixture)
+	for n := 0; n < len(data); n++ {
+		if _, err := traceUtils.DecodeFrames(data[:n]); err == nil {
+			t.Errorf("expected an error for input truncated to %d of %d bytes", n, len(data))
+		}
+	}
+}
+
+func FuzzDecodeFrames(f *testing.F) {
+	f.Add(traceUtils.EncodeFrames(encodingFixture))
+	f.Add(traceUtils.EncodeFrames(nil))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frames, err := traceUtils.DecodeFrames(data)
+		if err != nil {
+			return
+		}
+		// whatever decodes must survive another round trip
+		again, err := traceUtils.DecodeFrames(traceUtils.EncodeFrames(frames))
+		if err != nil {
+			t.Fatalf("re-encoded frames don't decode: %v", err)
+		}
+		if !reflect.DeepEqual(again, frames) {
+			t.Fatalf("round trip changed frames:\n%#v\n%#v", frames, again)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package traceUtils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitBlameTimeout bounds a single git invocation so a slow repository can't stall a crash report.
+const gitBlameTimeout = 2 * time.Second
+
+var (
+	gitPath     string
+	gitLookOnce sync.Once
+	// blames caches the blame of "file:line", empty when unavailable, for the lifetime of the process
+	blames sync.Map
+)
+
+// gitBlame returns " blame=<short hash> (<author>)" for file:line, empty when git isn't installed, the file isn't
+// tracked or the line isn't committed yet.
+func gitBlame(file string, line int) string {
+	if file == "" || line < 1 || !filepath.IsAbs(file) {
+		return ""
+	}
+	key := file + ":" + strconv.Itoa(line)
+	if cached, ok := blames.Load(key); ok {
+		return cached.(string)
+	}
+	blame := runGitBlame(file, line)
+	blames.Store(key, blame)
+	return blame
+}
+
+func runGitBlame(file string, line int) string {
+	gitLookOnce.Do(func() {
+		gitPath, _ = exec.LookPath("git")
+	})
+	if gitPath == "" {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitBlameTimeout)
+	defer cancel()
+	n := strconv.Itoa(line)
+	cmd := exec.CommandContext(ctx, gitPath, "-C", filepath.Dir(file), "blame", "--porcelain", "-L", n+","+n, "--", filepath.Base(file))
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+
+	// porcelain output starts with "<hash> <orig line> <final line> <count>" followed by "author <name>"
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if !scanner.Scan() {
+		return ""
+	}
+	hash := strings.SplitN(scanner.Text(), " ", 2)[0]
+	if len(hash) < 7 || strings.Trim(hash, "0") == "" {
+		return ""
+	}
+	blame := " blame=" + hash[:7]
+	for scanner.Scan() {
+		if author := strings.TrimPrefix(scanner.Text(), "author "); author != scanner.Text() {
+			blame += " (" + author + ")"
+			break
+		}
+	}
+	return blame
+}
@@ -0,0 +1,48 @@
+package traceUtils
+
+// GoroutineInfo - a goroutine found by GoroutineSnapshot.NewSince, kept as an alias of Goroutine.
+type GoroutineInfo = Goroutine
+
+// GoroutineSnapshot - the goroutines alive at one point in time, a baseline for goroutine leak detection.
+//
+//	before := SnapshotGoroutines()
+//	defer func() {
+//		if leaked := SnapshotGoroutines().NewSince(before); len(leaked) > 0 {
+//			t.Errorf("%d goroutines leaked", len(leaked))
+//		}
+//	}()
+type GoroutineSnapshot struct {
+	goroutines []Goroutine
+}
+
+// SnapshotGoroutines - captures the goroutines currently alive.
+func SnapshotGoroutines() GoroutineSnapshot {
+	return GoroutineSnapshot{goroutines: CaptureAllGoroutines()}
+}
+
+// NewSince - returns the goroutines of s that weren't in prev. Goroutines are matched by the site that created them,
+// so with three goroutines started at a site in prev and five in s, two of them are reported.
+func (s GoroutineSnapshot) NewSince(prev GoroutineSnapshot) []GoroutineInfo {
+	baseline := make(map[string]int)
+	for _, g := range prev.goroutines {
+		baseline[creationSite(g)]++
+	}
+
+	var fresh []GoroutineInfo
+	for _, g := range s.goroutines {
+		if site := creationSite(g); baseline[site] > 0 {
+			baseline[site]--
+			continue
+		}
+		fresh = append(fresh, g)
+	}
+	return fresh
+}
+
+// creationSite fingerprints the go statement that started g, empty for the main goroutine.
+func creationSite(g Goroutine) string {
+	if g.CreatedBy == nil {
+		return ""
+	}
+	return g.CreatedBy.ID()
+}
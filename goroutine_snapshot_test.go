@@ -0,0 +1,27 @@
+package traceUtils_test
+
+import (
+	"strings"
+	"testing"
+
+	traceUtils "github.com/karsto/common"
+)
+
+func TestNewSince(t *testing.T) {
+	defer parkGoroutines(3)()
+	before := traceUtils.SnapshotGoroutines()
+
+	// started at the same site as the three in the baseline, matching by site must still report both
+	defer parkGoroutines(2)()
+	leaked := traceUtils.SnapshotGoroutines().NewSince(before)
+
+	parked := 0
+	for _, g := range leaked {
+		if g.CreatedBy != nil && strings.HasSuffix(g.CreatedBy.Func, ".parkGoroutines") {
+			parked++
+		}
+	}
+	if parked != 2 {
+		t.Fatalf("expected 2 new parked goroutines, got %d of %d reported", parked, len(leaked))
+	}
+}
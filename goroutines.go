@@ -0,0 +1,392 @@
+package traceUtils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Goroutine - a single goroutine parsed from the runtime's all-goroutines dump, see CaptureAllGoroutines.
+type Goroutine struct {
+	ID        int64   `json:"id"`
+	State     string  `json:"state"`
+	Frames    []Frame `json:"frames"`
+	CreatedBy *Frame  `json:"createdBy,omitempty"` // the go statement that started the goroutine, nil for the main goroutine
+	Elided    bool    `json:"elided,omitempty"`    // the runtime left out frames of a deep stack
+
+	duplicates int // number of goroutines with the same stack this one stands for, see WithDedupeGoroutines
+}
+
+// CaptureAllGoroutines - returns every live goroutine with its state and frames, for callers that want to inspect
+// or render the stacks themselves. NewAllGoroutinesTrace and AllGoroutinesJSON are built on top of it.
+// Of opts only WithIncludeSelf applies, this package's frames are elided by default.
+func CaptureAllGoroutines(opts ...StackTraceOption) []Goroutine {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return captureGoroutines(cfg)
+}
+
+// captureGoroutines parses the all-goroutines dump, eliding this package's frames unless cfg.IncludeSelf.
+func captureGoroutines(cfg StackTraceConfig) []Goroutine {
+	goroutines := parseGoroutines(allGoroutinesDump())
+	for i := range goroutines {
+		goroutines[i] = elideSelfGoroutine(goroutines[i], cfg)
+	}
+	return goroutines
+}
+
+// elideSelfGoroutine drops this package's frames from g unless cfg.IncludeSelf, see elideSelf.
+func elideSelfGoroutine(g Goroutine, cfg StackTraceConfig) Goroutine {
+	if !cfg.IncludeSelf {
+		g.Frames = elideSelf(g.Frames)
+	}
+	return g
+}
+
+// NewAllGoroutinesTrace - returns the stacks of all goroutines formatted according to opts,
+// each block is headed by the runtime's "goroutine N [state]:" line. All goroutines share one source cache,
+// so each file is read at most once per call however many goroutines stand in it.
+func NewAllGoroutinesTrace(opts ...StackTraceOption) []byte {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	goroutines := filterGoroutines(captureGoroutines(cfg), cfg.GoroutineStates)
+	if cfg.DedupeGoroutines {
+		goroutines = dedupeGoroutines(goroutines)
+	}
+	more := 0
+	if cfg.MaxGoroutines > 0 && len(goroutines) > cfg.MaxGoroutines {
+		more = len(goroutines) - cfg.MaxGoroutines
+		goroutines = goroutines[:cfg.MaxGoroutines]
+	}
+	if cfg.RepoRelative && len(goroutines) > 0 && len(goroutines[0].Frames) > 0 {
+		cfg.repoRoot = repoRoot(goroutines[0].Frames[0].File)
+	}
+	sources := newSourceCache(cfg)
+	blocks := make([][]byte, len(goroutines), len(goroutines)+1)
+	render := func(i int) {
+		blocks[i] = renderGoroutine(goroutines[i], cfg, sources)
+	}
+
+	if cfg.Parallelism <= 1 {
+		for i := range goroutines {
+			render(i)
+		}
+	} else {
+		// contiguous chunks keep coordination overhead negligible next to rendering
+		chunk := (len(goroutines) + cfg.Parallelism - 1) / cfg.Parallelism
+		var wg sync.WaitGroup
+		for start := 0; start < len(goroutines); start += chunk {
+			end := start + chunk
+			if end > len(goroutines) {
+				end = len(goroutines)
+			}
+			wg.Add(1)
+			go func(start, end int) {
+				defer wg.Done()
+				for i := start; i < end; i++ {
+					render(i)
+				}
+			}(start, end)
+		}
+		wg.Wait()
+	}
+	if more > 0 {
+		blocks = append(blocks, moreGoroutines(more))
+	}
+
+	return indentLines(bytes.Join(blocks, []byte(cfg.FrameSeparator+cfg.FrameSeparator)), cfg.GlobalIndent)
+}
+
+// WriteAllGoroutines - writes the output of NewAllGoroutinesTrace to w goroutine by goroutine, so the rendered
+// dump of a server with tens of thousands of goroutines is never held in memory as a whole. The raw runtime dump
+// still is, runtime.Stack can only fill a single buffer. WithDedupeGoroutines needs every goroutine before
+// writing the first one and WithParallelism is ignored.
+func WriteAllGoroutines(w io.Writer, opts ...StackTraceOption) error {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.GlobalIndent != "" {
+		w = PrefixWriter(w, cfg.GlobalIndent)
+	}
+
+	dump := allGoroutinesDump()
+	sources := newSourceCache(cfg)
+	separator := []byte(cfg.FrameSeparator + cfg.FrameSeparator)
+	written, more := 0, 0
+	var err error
+	writeBlock := func(block []byte) {
+		if err != nil {
+			return
+		}
+		if written > 0 {
+			if _, err = w.Write(separator); err != nil {
+				return
+			}
+		}
+		_, err = w.Write(block)
+	}
+	write := func(g Goroutine) {
+		g = elideSelfGoroutine(g, cfg)
+		if cfg.MaxGoroutines > 0 && written >= cfg.MaxGoroutines {
+			more++
+			return
+		}
+		if written == 0 && cfg.RepoRelative && len(g.Frames) > 0 {
+			cfg.repoRoot = repoRoot(g.Frames[0].File)
+		}
+		writeBlock(renderGoroutine(g, cfg, sources))
+		written++
+	}
+
+	if cfg.DedupeGoroutines {
+		for _, g := range dedupeGoroutines(filterGoroutines(parseGoroutines(dump), cfg.GoroutineStates)) {
+			write(g)
+		}
+	} else {
+		eachGoroutine(dump, func(g Goroutine) {
+			if len(filterGoroutines([]Goroutine{g}, cfg.GoroutineStates)) > 0 {
+				write(g)
+			}
+		})
+	}
+	if more > 0 {
+		writeBlock(moreGoroutines(more))
+	}
+	return err
+}
+
+// moreGoroutines is the footer of a dump capped by WithMaxGoroutines.
+func moreGoroutines(n int) []byte {
+	return []byte("... (" + strconv.Itoa(n) + " more goroutines)")
+}
+
+// AllGoroutinesJSON - returns the stacks of all goroutines as a single JSON document,
+// {"total": N, "count": M, "goroutines": [{"id", "state", "frames"}, ...]}, for shipping deadlock snapshots to a
+// backend. total counts all goroutines, count those left after WithGoroutineStateFilter. File paths follow the path
+// options such as WithPathRedactor. Frames parsed from the dump carry no PC.
+func AllGoroutinesJSON(opts ...StackTraceOption) ([]byte, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	all := captureGoroutines(cfg)
+	total := len(all)
+	goroutines := filterGoroutines(all, cfg.GoroutineStates)
+	if cfg.RepoRelative && len(goroutines) > 0 && len(goroutines[0].Frames) > 0 {
+		cfg.repoRoot = repoRoot(goroutines[0].Frames[0].File)
+	}
+	for i := range goroutines {
+		g := &goroutines[i]
+		if cfg.MaxFrames > 0 && len(g.Frames) > cfg.MaxFrames {
+			g.Frames = g.Frames[:cfg.MaxFrames]
+		}
+		g.Frames = displayFrames(g.Frames, cfg)
+		if g.CreatedBy != nil {
+			g.CreatedBy = &displayFrames([]Frame{*g.CreatedBy}, cfg)[0]
+		}
+	}
+	return json.Marshal(struct {
+		Total      int         `json:"total"`
+		Count      int         `json:"count"`
+		Goroutines []Goroutine `json:"goroutines"`
+	}{total, len(goroutines), goroutines})
+}
+
+// filterGoroutines keeps the goroutines whose state contains any of states, an empty filter keeps all.
+func filterGoroutines(goroutines []Goroutine, states []string) []Goroutine {
+	if len(states) == 0 {
+		return goroutines
+	}
+	kept := goroutines[:0]
+	for _, g := range goroutines {
+		for _, state := range states {
+			if strings.Contains(g.State, state) {
+				kept = append(kept, g)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// dedupeGoroutines keeps the first goroutine of each group sharing the same stack Fingerprint, counting the group.
+func dedupeGoroutines(goroutines []Goroutine) []Goroutine {
+	groups := make(map[string]int, len(goroutines))
+	var kept []Goroutine
+	for _, g := range goroutines {
+		fingerprint := Fingerprint(g.Frames)
+		if i, ok := groups[fingerprint]; ok {
+			kept[i].duplicates++
+			continue
+		}
+		groups[fingerprint] = len(kept)
+		g.duplicates = 1
+		kept = append(kept, g)
+	}
+	return kept
+}
+
+// renderGoroutine formats a single goroutine block.
+func renderGoroutine(g Goroutine, cfg StackTraceConfig, sources *sourceCache) []byte {
+	frames := g.Frames
+	size := ""
+	if cfg.StackSize {
+		// the runtime doesn't report stack sizes in its dumps, the frame count is the closest proxy
+		size = " (" + strconv.Itoa(len(frames))
+		if g.Elided {
+			size += "+"
+		}
+		size += " frames)"
+	}
+	if cfg.MaxFrames > 0 && len(frames) > cfg.MaxFrames {
+		frames = frames[:cfg.MaxFrames]
+	}
+	header := "goroutine " + strconv.FormatInt(g.ID, 10) + " [" + g.State + "]"
+	if g.duplicates > 1 {
+		header += " (x" + strconv.Itoa(g.duplicates) + " goroutines)"
+	}
+	out := []byte(header + size + ":" + cfg.FrameSeparator)
+	return append(out, renderFrames(frames, cfg, sources)...)
+}
+
+// allGoroutinesDump returns runtime.Stack for all goroutines, growing the buffer until the dump fits.
+func allGoroutinesDump() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+// parseGoroutines parses the runtime's all-goroutines dump format:
+//
+//	goroutine 1 [chan receive, 2 minutes]:
+//	main.worker(0xc000010000)
+//		/app/main.go:42 +0x1d
+//	created by main.main in goroutine 1
+//		/app/main.go:12 +0x5b
+//
+// Lines that don't fit the format are skipped, "...additional frames elided..." marks the goroutine as Elided.
+func parseGoroutines(dump []byte) []Goroutine {
+	var goroutines []Goroutine
+	eachGoroutine(dump, func(g Goroutine) {
+		goroutines = append(goroutines, g)
+	})
+	return goroutines
+}
+
+// eachGoroutine parses dump like parseGoroutines, handing each goroutine to fn as soon as it is complete.
+func eachGoroutine(dump []byte, fn func(Goroutine)) {
+	var current Goroutine
+	started := false
+	var pending *Frame // func line waiting for its file:line
+	createdBy := false
+
+	for len(dump) > 0 {
+		var line string
+		if i := bytes.IndexByte(dump, '\n'); i >= 0 {
+			line, dump = string(dump[:i]), dump[i+1:]
+		} else {
+			line, dump = string(dump), nil
+		}
+
+		switch {
+		case strings.HasPrefix(line, "goroutine "):
+			if g, ok := parseGoroutineHeader(line); ok {
+				if started {
+					fn(current)
+				}
+				current, started = g, true
+			}
+			pending = nil
+		case !started || line == "":
+			pending = nil
+		case strings.HasPrefix(line, "...") && strings.Contains(line, "frames elided"):
+			current.Elided = true
+		case strings.HasPrefix(line, "\t"):
+			if pending == nil {
+				continue
+			}
+			pending.File, pending.Line = parseFileLine(line[1:])
+			if createdBy {
+				current.CreatedBy = pending
+			} else {
+				current.Frames = append(current.Frames, *pending)
+			}
+			pending = nil
+		case strings.HasPrefix(line, "created by "):
+			name := strings.TrimPrefix(line, "created by ")
+			if i := strings.Index(name, " in goroutine "); i >= 0 {
+				name = name[:i]
+			}
+			pending, createdBy = &Frame{Func: name}, true
+		default:
+			pending, createdBy = &Frame{Func: trimArgs(line)}, false
+		}
+	}
+	if started {
+		fn(current)
+	}
+}
+
+// parseGoroutineHeader parses "goroutine 1 [running]:". Scheduler annotations of crash dumps such as
+// "goroutine 1 gp=0xc000002380 m=0 mp=0x5c7a40 [running]:" are skipped, runtime.Stack never writes them.
+func parseGoroutineHeader(line string) (Goroutine, bool) {
+	rest := strings.TrimPrefix(line, "goroutine ")
+	end := strings.IndexByte(rest, ' ')
+	if end < 0 {
+		return Goroutine{}, false
+	}
+	id, err := strconv.ParseInt(rest[:end], 10, 64)
+	if err != nil {
+		return Goroutine{}, false
+	}
+
+	g := Goroutine{ID: id}
+	open, close := strings.IndexByte(rest, '['), strings.LastIndexByte(rest, ']')
+	if open < 0 || close < open {
+		return g, true
+	}
+	g.State = rest[open+1 : close]
+	return g, true
+}
+
+// parseFileLine parses "/app/main.go:42 +0x1d", the offset is optional.
+func parseFileLine(s string) (string, int) {
+	if i := strings.LastIndex(s, " +0x"); i >= 0 {
+		s = s[:i]
+	}
+	colon := strings.LastIndexByte(s, ':')
+	if colon < 0 {
+		return s, 0
+	}
+	line, err := strconv.Atoi(s[colon+1:])
+	if err != nil {
+		return s, 0
+	}
+	return s[:colon], line
+}
+
+// trimArgs strips the argument list from a dumped call such as "main.(*T).M(0xc000010000, 0x1)".
+func trimArgs(call string) string {
+	if strings.HasSuffix(call, ")") {
+		if i := strings.LastIndexByte(call, '('); i > 0 {
+			return call[:i]
+		}
+	}
+	return call
+}
@@ -0,0 +1,170 @@
+package traceUtils_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	traceUtils "github.com/karsto/common"
+)
+
+func TestAllGoroutinesNoSelfFrames(t *testing.T) {
+	for _, g := range traceUtils.CaptureAllGoroutines() {
+		for _, f := range g.Frames {
+			if strings.HasPrefix(f.Func, self) {
+				t.Fatalf("package frame %s leaked into goroutine %d", f.Func, g.ID)
+			}
+		}
+	}
+
+	opts := []traceUtils.StackTraceOption{traceUtils.WithShortFuncNames(false), traceUtils.WithIncludeSourceCode(false)}
+	var written bytes.Buffer
+	if err := traceUtils.WriteAllGoroutines(&written, opts...); err != nil {
+		t.Fatal(err)
+	}
+	json, err := traceUtils.AllGoroutinesJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := map[string]string{
+		"NewAllGoroutinesTrace": string(traceUtils.NewAllGoroutinesTrace(opts...)),
+		"WriteAllGoroutines":    written.String(),
+		"AllGoroutinesJSON":     string(json),
+	}
+	for name, out := range outputs {
+		if strings.Contains(out, self) {
+			t.Errorf("%s: package frame leaked into the dump:\n%s", name, out)
+		}
+		if !strings.Contains(out, "TestAllGoroutinesNoSelfFrames") {
+			t.Errorf("%s: expected the calling goroutine, got:\n%s", name, out)
+		}
+	}
+}
+
+// parkGoroutines starts n goroutines blocked in a mix of channel, mutex, wait group and timer waits, so their stacks
+// run through different source files. They are released when the returned func is called.
+func parkGoroutines(n int) (release func()) {
+	done := make(chan struct{})
+	var mu sync.Mutex
+	var wait sync.WaitGroup
+	mu.Lock()
+	wait.Add(1)
+	parks := []func(){
+		func() { <-done },
+		func() { mu.Lock(); mu.Unlock() },
+		func() { wait.Wait() },
+		func() {
+			select {
+			case <-done:
+			case <-time.After(time.Hour):
+			}
+		},
+	}
+	var started sync.WaitGroup
+	started.Add(n)
+	for i := 0; i < n; i++ {
+		go func(park func()) {
+			started.Done()
+			park()
+		}(parks[i%len(parks)])
+	}
+	started.Wait()
+	time.Sleep(10 * time.Millisecond) // let them reach their waits
+	return func() {
+		close(done)
+		mu.Unlock()
+		wait.Done()
+	}
+}
+
+func TestOnFileLoadParallel(t *testing.T) {
+	defer parkGoroutines(32)()
+
+	loaded := map[string]bool{}
+	traceUtils.NewAllGoroutinesTrace(
+		traceUtils.WithParallelism(8),
+		traceUtils.WithOnFileLoad(func(path string, ok bool) {
+			loaded[path] = ok
+			// a slow callback, e.g. one logging each path, would overlap with loads of the other workers
+			time.Sleep(time.Millisecond)
+		}),
+	)
+	if len(loaded) == 0 {
+		t.Fatal("expected source files to be loaded")
+	}
+}
+
+func TestAllGoroutinesReadEachFileOnce(t *testing.T) {
+	defer parkGoroutines(16)()
+
+	for _, parallelism := range []int{1, 8} {
+		loads := map[string]int{}
+		traceUtils.NewAllGoroutinesTrace(
+			traceUtils.WithParallelism(parallelism),
+			traceUtils.WithOnFileLoad(func(path string, ok bool) {
+				loads[path]++
+			}),
+		)
+		if len(loads) == 0 {
+			t.Fatalf("parallelism %d: expected source files to be loaded", parallelism)
+		}
+		for path, n := range loads {
+			if n > 1 {
+				t.Errorf("parallelism %d: %s read %d times", parallelism, path, n)
+			}
+		}
+	}
+}
+
+func BenchmarkAllGoroutinesTrace(b *testing.B) {
+	defer parkGoroutines(1000)()
+
+	for _, parallelism := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				traceUtils.NewAllGoroutinesTrace(traceUtils.WithParallelism(parallelism))
+			}
+		})
+	}
+}
+
+func TestAllGoroutinesJSONRedactsPaths(t *testing.T) {
+	defer parkGoroutines(4)()
+
+	out, err := traceUtils.AllGoroutinesJSON(
+		traceUtils.WithPathRedactor(func(string) string { return "X" }),
+		traceUtils.WithGoroutineStateFilter("chan receive"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Total      int `json:"total"`
+		Count      int `json:"count"`
+		Goroutines []struct {
+			Frames    []traceUtils.Frame `json:"frames"`
+			CreatedBy *traceUtils.Frame  `json:"createdBy"`
+		} `json:"goroutines"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Count == 0 || doc.Count != len(doc.Goroutines) || doc.Total <= doc.Count {
+		t.Errorf("expected the filtered count below the total, got count %d of %d", doc.Count, doc.Total)
+	}
+	for _, g := range doc.Goroutines {
+		frames := g.Frames
+		if g.CreatedBy != nil {
+			frames = append(frames, *g.CreatedBy)
+		}
+		for _, f := range frames {
+			if f.File != "X" {
+				t.Fatalf("unredacted path %s in:\n%s", f.File, out)
+			}
+		}
+	}
+}
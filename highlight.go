@@ -0,0 +1,87 @@
+package traceUtils
+
+import "strings"
+
+// highlightGo colors a single line of Go source with a minimal lexer, keywords, strings, comments and numbers each
+// get their color of scheme. Literals and block comments left open at the end of the line are colored to the end.
+func highlightGo(code []byte, scheme ColorScheme) string {
+	var out strings.Builder
+	token := func(color string, text []byte) {
+		if color == "" {
+			out.Write(text)
+			return
+		}
+		out.WriteString(color)
+		out.Write(text)
+		out.WriteString(colorReset)
+	}
+
+	for i := 0; i < len(code); {
+		c := code[i]
+		switch {
+		case c == '/' && i+1 < len(code) && code[i+1] == '/':
+			token(scheme.Comment, code[i:])
+			i = len(code)
+		case c == '/' && i+1 < len(code) && code[i+1] == '*':
+			end := len(code)
+			if close := strings.Index(string(code[i+2:]), "*/"); close >= 0 {
+				end = i + 2 + close + 2
+			}
+			token(scheme.Comment, code[i:end])
+			i = end
+		case c == '"' || c == '\'' || c == '`':
+			end := i + 1
+			for end < len(code) && code[end] != c {
+				if code[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end < len(code) {
+				end++ // closing quote
+			} else {
+				end = len(code)
+			}
+			token(scheme.String, code[i:end])
+			i = end
+		case isDigit(c) || (c == '.' && i+1 < len(code) && isDigit(code[i+1])):
+			end := i + 1
+			for end < len(code) && (isIdentByte(code[end]) || code[end] == '.') {
+				end++
+			}
+			token(scheme.Number, code[i:end])
+			i = end
+		case isIdentByte(c):
+			end := i + 1
+			for end < len(code) && isIdentByte(code[end]) {
+				end++
+			}
+			if goKeywords[string(code[i:end])] {
+				token(scheme.Keyword, code[i:end])
+			} else {
+				out.Write(code[i:end])
+			}
+			i = end
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isIdentByte reports whether c can be part of an identifier, non-ASCII bytes are assumed to be letters.
+func isIdentByte(c byte) bool {
+	return c == '_' || isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'z') || c >= 0x80
+}
+
+var goKeywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true, "defer": true,
+	"else": true, "fallthrough": true, "for": true, "func": true, "go": true, "goto": true, "if": true,
+	"import": true, "interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+}
@@ -0,0 +1,35 @@
+package traceUtils
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Handler - wraps next so panics are recovered, logged with their stack trace and answered with a 500.
+// The trace starts at the panic site inside next rather than at this wrapper, logs go to the standard
+// logger unless WithLogSink is given. http.ErrAbortHandler is re-panicked as net/http expects.
+func Handler(next http.Handler, opts ...StackTraceOption) http.Handler {
+	opts = append(opts[:len(opts):len(opts)], fromPanic())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			t := newTrace(1, opts)
+			msg := fmt.Sprintf("panic serving %s %s: %v\n%s\n", r.Method, r.URL.Path, recovered, t.render())
+			if t.cfg.LogSink != nil {
+				t.cfg.LogSink.Write([]byte(msg))
+			} else {
+				log.Print(msg)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+package traceUtils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NewStackTraceLogfmt - returns the stack trace in logfmt, one `frame=0 func=... file=... line=...` line per frame.
+// Values containing spaces, quotes or equal signs are quoted, source is added as a quoted src= field when included.
+func NewStackTraceLogfmt(opts ...StackTraceOption) []byte {
+	t := newTrace(1, opts)
+	cfg := t.cfg
+	sources := newSourceCache(cfg)
+
+	var out strings.Builder
+	for i, f := range t.frames {
+		if i > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString("frame=" + strconv.Itoa(i))
+		out.WriteString(" func=" + logfmtValue(string(resolveFuncName(displayFunc(cfg, f.Func), cfg.ShortFuncNames))))
+		out.WriteString(" file=" + logfmtValue(displayPath(cfg, f.File)))
+		if cfg.ShowLineNumbers {
+			out.WriteString(" line=" + strconv.Itoa(f.Line))
+		}
+		if cfg.IncludePC {
+			out.WriteString(" pc=" + logfmtValue(formatPC(f.PC, cfg.PCFormat)))
+		}
+		if cfg.IncludeSourceCode {
+			out.WriteString(" src=" + strconv.Quote(string(sources.line(f.File, f.Line))))
+		}
+	}
+	return []byte(out.String())
+}
+
+// logfmtValue quotes v when it can't be written bare.
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\r\n\"=\\") {
+		return strconv.Quote(v)
+	}
+	return v
+}
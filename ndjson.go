@@ -0,0 +1,52 @@
+package traceUtils
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// ndjsonFrame is a single line of NewStackTraceNDJSON.
+type ndjsonFrame struct {
+	Frame int    `json:"frame"`
+	Func  string `json:"func"`
+	File  string `json:"file"`
+	Line  int    `json:"line,omitempty"`
+	PC    string `json:"pc,omitempty"`
+	Src   string `json:"src,omitempty"`
+}
+
+// NewStackTraceNDJSON - returns the stack trace as newline-delimited JSON, one object per frame with its index,
+// `{"frame":0,"func":"...","file":"...","line":42}`, for log shippers that split on newlines. Fields follow the same
+// options as NewStackTraceLogfmt. An empty stack gives no lines unless WithNDJSONSentinel is set.
+func NewStackTraceNDJSON(opts ...StackTraceOption) []byte {
+	t := newTrace(1, opts)
+	cfg := t.cfg
+	sources := newSourceCache(cfg)
+
+	if len(t.frames) == 0 {
+		return []byte(cfg.NDJSONSentinel)
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	for i, f := range t.frames {
+		line := ndjsonFrame{
+			Frame: i,
+			Func:  string(resolveFuncName(displayFunc(cfg, f.Func), cfg.ShortFuncNames)),
+			File:  displayPath(cfg, f.File),
+		}
+		if cfg.ShowLineNumbers {
+			line.Line = f.Line
+		}
+		if cfg.IncludePC && f.PC != 0 {
+			line.PC = formatPC(f.PC, cfg.PCFormat)
+		}
+		if cfg.IncludeSourceCode {
+			line.Src = string(sources.line(f.File, f.Line))
+		}
+		// Encode terminates every object with a newline, the frames can't fail to marshal
+		enc.Encode(line)
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte{'\n'})
+}
@@ -0,0 +1,137 @@
+package traceUtils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// optionsFromMapKeys maps config keys to a constructor converting the raw value into an option.
+var optionsFromMapKeys = map[string]func(key string, v interface{}) (StackTraceOption, error){
+	"skip_frames":          intOption(WithSkipFrames),
+	"max_frames":           intOption(WithMaxFrames),
+	"source_context":       intOption(WithSourceContext),
+	"max_function_lines":   intOption(WithMaxFunctionLines),
+	"max_source_line_len":  intOption(WithMaxSourceLineLen),
+	"max_goroutines":       intOption(WithMaxGoroutines),
+	"wrap_width":           intOption(WithWrapWidth),
+	"max_func_name_len":    intOption(WithMaxFuncNameLen),
+	"include_source":       boolOption(WithIncludeSourceCode),
+	"include_pc":           boolOption(WithIncludePC),
+	"short_names":          boolOption(WithShortFuncNames),
+	"full_path":            boolOption(WithShowFullPath),
+	"line_numbers":         boolOption(WithShowLineNumbers),
+	"mark_inlined":         boolOption(WithMarkInlined),
+	"per_frame_id":         boolOption(WithPerFrameID),
+	"show_package":         boolOption(WithShowPackage),
+	"timing":               boolOption(WithTiming),
+	"strip_comments":       boolOption(WithStripComments),
+	"summary_footer":       boolOption(WithSummaryFooter),
+	"goroutine_count":      boolOption(WithGoroutineCount),
+	"uptime":               boolOption(WithUptime),
+	"enclosing_context":    boolOption(WithEnclosingContext),
+	"dedent_source":        boolOption(WithDedentSource),
+	"reverse_order":        boolOption(WithReverseOrder),
+	"order_banner":         boolOption(WithOrderBanner),
+	"function_progress":    boolOption(WithFunctionProgress),
+	"source_status":        boolOption(WithSourceStatus),
+	"pc_offset":            boolOption(WithPCOffset),
+	"fold_by_file":         boolOption(WithFoldByFile),
+	"truncate_func_tail":   boolOption(WithTruncateFuncTail),
+	"tree_indent":          boolOption(WithTreeIndent),
+	"normalize_paths":      boolOption(WithNormalizePaths),
+	"stable_key":           boolOption(WithStableKey),
+	"source_mtime":         boolOption(WithSourceMTime),
+	"caret":                boolOption(WithCaret),
+	"stable_closure_names": boolOption(WithStableClosureNames),
+	"origin_header":        boolOption(WithOriginHeader),
+	"unvendor_paths":       boolOption(WithUnvendorPaths),
+	"origin_colors":        boolOption(WithOriginColors),
+	"stack_size":           boolOption(WithStackSize),
+	"dedupe_goroutines":    boolOption(WithDedupeGoroutines),
+	"git_blame":            boolOption(WithGitBlame),
+	"qualified_receiver":   boolOption(WithQualifiedReceiver),
+	"box_drawing":          boolOption(WithBoxDrawing),
+	"ascii_only":           boolOption(WithASCIIOnly),
+	"error_chain":          boolOption(WithErrorChain),
+	"repo_relative":        boolOption(WithRepoRelative),
+	"syntax_highlight":     boolOption(WithSyntaxHighlight),
+	"thread_id":            boolOption(WithThreadID),
+	"package_context":      boolOption(WithPackageContext),
+	"native_format":        boolOption(WithNativeFormat),
+	"source_notes":         boolOption(WithSourceNotes),
+	"include_self":         boolOption(WithIncludeSelf),
+	"error_age":            boolOption(WithErrorAge),
+	"frame_separator":      stringOption(WithFrameSeparator),
+	"chunk_separator":      stringOption(WithChunkSeparator),
+	"ndjson_sentinel":      stringOption(WithNDJSONSentinel),
+	"pc_format":            stringOption(WithPCFormat),
+	"global_indent":        stringOption(WithGlobalIndent),
+	"chunk_format":         stringOption(WithChunkFormat),
+	"chunk_indentation":    stringOption(WithChunkIndentation),
+	"editor_links":         stringOption(WithEditorLinks),
+}
+
+// OptionsFromMap - converts a decoded YAML/JSON config into options so trace formatting can be tuned without recompiling.
+// Unknown keys and values of the wrong type return an error.
+func OptionsFromMap(m map[string]interface{}) ([]StackTraceOption, error) {
+	// sorted so errors and option order are deterministic
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	opts := make([]StackTraceOption, 0, len(m))
+	for _, k := range keys {
+		toOption, ok := optionsFromMapKeys[k]
+		if !ok {
+			return nil, fmt.Errorf("unknown stack trace option '%s'", k)
+		}
+		opt, err := toOption(k, m[k])
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+func intOption(fn func(int) StackTraceOption) func(string, interface{}) (StackTraceOption, error) {
+	return func(key string, v interface{}) (StackTraceOption, error) {
+		switch n := v.(type) {
+		case int:
+			return fn(n), nil
+		case int64:
+			return fn(int(n)), nil
+		case uint64:
+			return fn(int(n)), nil
+		case float64: // encoding/json decodes all numbers as float64
+			if n != math.Trunc(n) {
+				return nil, fmt.Errorf("invalid %s: must be a whole number, found %v", key, v)
+			}
+			return fn(int(n)), nil
+		}
+		return nil, fmt.Errorf("invalid %s: must be a number, found %T", key, v)
+	}
+}
+
+func boolOption(fn func(bool) StackTraceOption) func(string, interface{}) (StackTraceOption, error) {
+	return func(key string, v interface{}) (StackTraceOption, error) {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: must be a bool, found %T", key, v)
+		}
+		return fn(b), nil
+	}
+}
+
+func stringOption(fn func(string) StackTraceOption) func(string, interface{}) (StackTraceOption, error) {
+	return func(key string, v interface{}) (StackTraceOption, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: must be a string, found %T", key, v)
+		}
+		return fn(s), nil
+	}
+}
@@ -0,0 +1,98 @@
+package traceUtils
+
+import (
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// frameOrigin classifies where a frame's code comes from.
+type frameOrigin int
+
+const (
+	originApp frameOrigin = iota
+	originStdlib
+	originDeps
+)
+
+// ColorScheme - the ANSI escape sequences WithOriginColors uses per frame origin and WithSyntaxHighlight uses
+// per token category, an empty sequence leaves those uncolored.
+type ColorScheme struct {
+	App    string
+	Stdlib string
+	Deps   string
+
+	Keyword string
+	String  string
+	Comment string
+	Number  string
+}
+
+// DefaultColorScheme - bold app frames, dim stdlib frames and cyan dependency frames,
+// magenta keywords, green strings, gray comments and yellow numbers.
+var DefaultColorScheme = ColorScheme{
+	App:    "\x1b[1m",
+	Stdlib: "\x1b[2m",
+	Deps:   "\x1b[36m",
+
+	Keyword: "\x1b[35m",
+	String:  "\x1b[32m",
+	Comment: "\x1b[90m",
+	Number:  "\x1b[33m",
+}
+
+const colorReset = "\x1b[0m"
+
+// mainModulePath is the module path of the running binary, empty when build info isn't available.
+var mainModulePath = func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Path
+	}
+	return ""
+}()
+
+// classifyOrigin tells app, stdlib and dependency frames apart by the package of fullName. App frames are those
+// matching one of inApp's prefixes or, when there are none, of the main module. Standard library import paths
+// have no dot in their first element, everything else is a dependency.
+func classifyOrigin(fullName string, inApp []string) frameOrigin {
+	pkg := funcPackage(fullName)
+	if len(inApp) > 0 {
+		for _, prefix := range inApp {
+			if strings.HasPrefix(pkg, prefix) {
+				return originApp
+			}
+		}
+	} else {
+		// external test packages belong to the module of the package they test
+		pkg := strings.TrimSuffix(pkg, "_test")
+		switch {
+		case pkg == "main" || strings.HasPrefix(pkg, "main."):
+			return originApp
+		case mainModulePath != "" && (pkg == mainModulePath || strings.HasPrefix(pkg, mainModulePath+"/")):
+			return originApp
+		}
+	}
+	if pkg == "" || !strings.Contains(strings.SplitN(pkg, "/", 2)[0], ".") {
+		return originStdlib
+	}
+	return originDeps
+}
+
+// originColor returns the escape sequence frames of fullName are colored with, empty when color is off.
+func originColor(cfg StackTraceConfig, fullName string) string {
+	if !cfg.OriginColors || colorDisabled() {
+		return ""
+	}
+	switch classifyOrigin(fullName, cfg.InAppPackages) {
+	case originApp:
+		return cfg.ColorScheme.App
+	case originStdlib:
+		return cfg.ColorScheme.Stdlib
+	}
+	return cfg.ColorScheme.Deps
+}
+
+// colorDisabled reports whether color is switched off globally following the NO_COLOR convention.
+func colorDisabled() bool {
+	return os.Getenv("NO_COLOR") != ""
+}
@@ -0,0 +1,45 @@
+package traceUtils
+
+import "io"
+
+// PrefixWriter - returns a writer that inserts prefix at the start of every line written to w,
+// e.g. to embed a trace from WriteStackTrace inside an existing log format.
+// A trailing newline does not produce a dangling prefix on the empty last line.
+func PrefixWriter(w io.Writer, prefix string) io.Writer {
+	return &prefixWriter{w: w, prefix: []byte(prefix), lineStart: true}
+}
+
+type prefixWriter struct {
+	w         io.Writer
+	prefix    []byte
+	lineStart bool // the prefix is owed before the next byte written
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if pw.lineStart {
+			if _, err := pw.w.Write(pw.prefix); err != nil {
+				return written, err
+			}
+			pw.lineStart = false
+		}
+
+		line := p
+		for i, b := range p {
+			if b == '\n' {
+				line = p[:i+1]
+				pw.lineStart = true
+				break
+			}
+		}
+
+		n, err := pw.w.Write(line)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(line):]
+	}
+	return written, nil
+}
@@ -1,5 +1,74 @@
 package traceUtils
 
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frame - a single captured stack frame.
+type Frame struct {
+	PC   uintptr `json:"pc,omitempty"`
+	Func string  `json:"func"` // full function name as reported by the runtime
+	File string  `json:"file"`
+	Line int     `json:"line"`
+	// Inlined reports whether the runtime inlined this frame into its caller, it then shares the caller's PC.
+	Inlined bool `json:"inlined,omitempty"`
+
+	faulted bool // PC is the faulting instruction of a signal panic rather than a call, see pcOffset
+}
+
+// ID - returns a short stable hash of the frame's func, file and line, deterministic across runs of the same build.
+func (f Frame) ID() string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s\x00%s:%d", f.Func, f.File, f.Line)
+	return fmt.Sprintf("%08x", h.Sum32())[:6]
+}
+
+// Source - reads the frame's source line from disk, space-trimmed, empty when the file or line isn't available.
+func (f Frame) Source() string {
+	data, err := os.ReadFile(f.File)
+	if err != nil {
+		return ""
+	}
+	line := source(bytes.Split(data, []byte{'\n'}), f.Line)
+	if bytes.Equal(line, unknown) {
+		return ""
+	}
+	return string(line)
+}
+
+// String - renders the frame the way NewStackTrace does with the default config, for ad hoc logging.
+func (f Frame) String() string {
+	cfg := defaultConfig()
+	return string(renderFrames([]Frame{f}, cfg, newSourceCache(cfg)))
+}
+
+// FileLines - the lines From through To, inclusive, of the file whose path ends in File.
+type FileLines struct {
+	File     string
+	From, To int
+}
+
+// SymbolResolver - resolves a PC to its symbol, e.g. from an external symbol map for obfuscated or stripped binaries.
+// Returning ok false declines and keeps the runtime's resolution.
+type SymbolResolver interface {
+	Resolve(pc uintptr) (name, file string, line int, ok bool)
+}
+
 // StackTraceConfig allows configuring the detail level of the printed stack trace.
 type StackTraceConfig struct {
 	SkipFrames        int
@@ -11,12 +80,145 @@ type StackTraceConfig struct {
 	FrameSeparator    string
 	ChunkSeparator    string
 	ChunkIndentation  string
+	// SortFrames, when set, reorders the captured frames before rendering.
+	// This breaks call-order semantics and is intended for analysis views, not normal traces.
+	SortFrames func(a, b Frame) bool
+	// SourceFS, when set, is used to read source instead of the local disk.
+	// SourcePrefix is stripped from recorded file paths before they are looked up in SourceFS.
+	SourceFS     fs.FS
+	SourcePrefix string
+	MarkInlined  bool
+	// PathRedactor, when set, transforms every file path before it is rendered, e.g. to hash or mask segments.
+	PathRedactor func(path string) string
+	PerFrameID   bool
+	MaxFrames    int // 0 renders all frames
+	ShowPackage  bool
+	// SourceRoots, when set, restricts source reads to files under one of these directories.
+	SourceRoots        []string
+	Timing             bool
+	StripComments      bool
+	SummaryFooter      bool
+	GoroutineCount     bool
+	EnclosingContext   bool
+	Parallelism        int // goroutines rendered concurrently by NewAllGoroutinesTrace, 0 or 1 is serial
+	Header             []string
+	SourceContext      int // lines shown before and after the frame's line, 0 shows only the line itself
+	DedentSource       bool
+	EditorLinks        string // vscode, idea or a url template with {file} and {line} placeholders
+	ReverseOrder       bool   // oldest frame (entry point) first
+	OrderBanner        bool
+	OrderBannerText    [2]string // describes the innermost and outermost end of the stack
+	FunctionProgress   bool
+	SymbolResolver     SymbolResolver
+	SourceReadBudget   int64 // bytes of source read per capture before the remaining frames render without source
+	Uptime             bool
+	DepthRange         []int     // inclusive [from, to] frame indexes after skipping, nil renders all
+	LogSink            io.Writer // where Handler logs recovered panics, the standard logger when nil
+	SourceStatus       bool
+	PCOffset           bool
+	FoldByFile         bool
+	FromDefer          bool // see WithFromDefer
+	MaxFuncNameLen     int  // runes, 0 doesn't truncate
+	TruncateFuncTail   bool // keep the tail (most specific part) of truncated func names instead of the head
+	OnFileLoad         func(path string, ok bool)
+	TreeIndent         bool
+	NormalizePaths     bool
+	StableKey          bool
+	SourceMTime        bool
+	Caret              bool
+	CaretColumn        func(f Frame) int // 1-based column the caret points at, 0 for the first non-whitespace character
+	MaxFunctionLines   int
+	GoroutineStates    []string
+	StableClosureNames bool
+	ProfileLabels      context.Context
+	WrapWidth          int
+	OriginHeader       bool
+	ChunkFormat        string // layout of the func and source line, see WithChunkFormat
+	UnvendorPaths      bool
+	ContextKeys        []interface{} // context keys CaptureWithContext reports, see WithContextKeys
+	OriginColors       bool
+	ColorScheme        ColorScheme
+	StackSize          bool
+	DedupeGoroutines   bool
+	GlobalIndent       string
+	GitBlame           bool
+	// SourceContextBefore and SourceContextAfter show an asymmetric source window, overriding SourceContext when set
+	SourceContextBefore int
+	SourceContextAfter  int
+	QualifiedReceiver   bool
+	BoxDrawing          bool
+	ASCIIOnly           bool
+	ErrorChain          bool
+	RepoRelative        bool
+	MaxGoroutines       int
+	SyntaxHighlight     bool
+	MaxSourceLineLen    int
+	NDJSONSentinel      string
+	InAppPackages       []string
+	MarkInApp           bool // set by WithInAppPackages, with no InAppPackages the main module is detected
+	SkipFileLines       []FileLines
+	PCFormat            string // see WithPCFormat
+	ThreadID            bool
+	PackageContext      bool
+	NativeFormat        bool
+	SourceNotes         bool
+	IncludeSelf         bool
+	ErrorAge            bool
+
+	repoRoot  string // detected per capture with RepoRelative
+	fromPanic bool   // set internally when capturing from a deferred recover, the trace then starts at the panic site
 }
 
 // NewStackTrace - returns a nicely formatted stack trace according to cfg, default is full verbose stack trace.
 // modified from https://github.com/gin-gonic/gin/blob/master/recovery.go#L111-L169
 func NewStackTrace(opts ...StackTraceOption) []byte {
-	cfg := StackTraceConfig{
+	return newStackTrace(1, opts)
+}
+
+// NewStackTraceIf - returns NewStackTrace when cond holds and nil otherwise. When cond is false it returns before
+// any stack walking, so hot logging code guarded by a level check never pays for an unused capture.
+func NewStackTraceIf(cond bool, opts ...StackTraceOption) []byte {
+	if !cond {
+		return nil
+	}
+	return newStackTrace(1, opts)
+}
+
+// WriteStackTrace - writes the stack trace NewStackTrace would return to w.
+func WriteStackTrace(w io.Writer, opts ...StackTraceOption) error {
+	_, err := w.Write(newStackTrace(1, opts))
+	return err
+}
+
+// SetDefaultOptions - registers opts as the defaults every trace starts from, meant to be called once at startup.
+// Options passed to NewStackTrace and the other entry points are applied on top and always win over these.
+// Calling it again replaces the previous defaults, calling it without opts restores the full verbose config.
+func SetDefaultOptions(opts ...StackTraceOption) {
+	defaultOptionsMu.Lock()
+	defer defaultOptionsMu.Unlock()
+	defaultOptions = append([]StackTraceOption(nil), opts...)
+}
+
+var (
+	defaultOptionsMu sync.RWMutex
+	defaultOptions   []StackTraceOption
+)
+
+// defaultConfig is the full verbose config with the options registered by SetDefaultOptions applied,
+// used when no options are given.
+func defaultConfig() StackTraceConfig {
+	cfg := builtinConfig()
+	defaultOptionsMu.RLock()
+	defer defaultOptionsMu.RUnlock()
+	for _, opt := range defaultOptions {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// builtinConfig is the full verbose config.
+func builtinConfig() StackTraceConfig {
+	return StackTraceConfig{
 		SkipFrames:        0,
 		IncludeSourceCode: true,
 		IncludePC:         true,
@@ -26,164 +228,2008 @@ func NewStackTrace(opts ...StackTraceOption) []byte {
 		FrameSeparator:    "\n",
 		ChunkSeparator:    "\n",
 		ChunkIndentation:  "\t",
+		OrderBannerText:   [2]string{"panic site", "entry point"},
+		MaxFunctionLines:  defaultMaxFunctionLines,
+		ChunkFormat:       defaultChunkFormat,
+		ContextKeys:       []interface{}{"request_id", "trace_id"},
+		ColorScheme:       DefaultColorScheme,
 	}
+}
+
+// newStackTrace captures and renders the stack, depth being the number of frames between newStackTrace and the
+// public entry point so that SkipFrames 0 always starts at that entry point.
+func newStackTrace(depth int, opts []StackTraceOption) (out []byte) {
+	// capturing must never mask the crash being reported, e.g. when a SymbolResolver panics inside a recover handler
+	defer func() {
+		if r := recover(); r != nil {
+			out = []byte(fmt.Sprintf("%s (panic while capturing trace: %v)", unknown, r))
+		}
+	}()
+	return newTrace(depth+1, opts).render()
+}
+
+// trace is a captured stack after skipping, limiting and sorting, ready to be rendered in any format.
+type trace struct {
+	cfg    StackTraceConfig
+	start  time.Time
+	header []string
+	frames []Frame
+	total  int // frames captured before any were hidden
+}
 
+// newTrace applies opts and captures the stack, depth works the same as for newStackTrace.
+// A panic while capturing, e.g. from a SortFrames func, keeps the frames captured so far and adds a header note
+// instead of propagating, so it is safe to capture from within a recover handler.
+func newTrace(depth int, opts []StackTraceOption) (t *trace) {
+	t = &trace{start: time.Now(), cfg: defaultConfig()}
+	var frames []Frame
+	defer func() {
+		if r := recover(); r != nil {
+			t.frames = frames
+			t.header = append(t.header, fmt.Sprintf("%s (panic while capturing trace: %v)", unknown, r))
+		}
+	}()
 	for _, opt := range opts {
-		opt(&cfg)
+		opt(&t.cfg)
 	}
+	cfg := t.cfg
 
-	var frames []string
-	var lines [][]byte
-	var lastFile string
-
-	for i := cfg.SkipFrames; ; i++ {
-		pc, file, line, ok := runtime.Caller(i)
-		if !ok {
-			break
+	t.header = append(t.header, cfg.Header...)
+	if cfg.GoroutineCount {
+		t.header = append(t.header, fmt.Sprintf("goroutines=%d", runtime.NumGoroutine()))
+	}
+	if cfg.Uptime {
+		t.header = append(t.header, fmt.Sprintf("uptime=%s", t.start.Sub(startTime).Round(time.Millisecond)))
+	}
+	if cfg.NativeFormat {
+		if id, ok := currentGoroutineID(); ok {
+			t.header = append(t.header, fmt.Sprintf("goroutine %d [running]:", id))
+		}
+	}
+	if cfg.ThreadID {
+		if tid, ok := osThreadID(); ok {
+			t.header = append(t.header, fmt.Sprintf("thread=%d", tid))
 		}
+	}
+	if cfg.ProfileLabels != nil {
+		var labels []string
+		pprof.ForLabels(cfg.ProfileLabels, func(key, value string) bool {
+			labels = append(labels, "label."+key+"="+value)
+			return true
+		})
+		sort.Strings(labels)
+		t.header = append(t.header, labels...)
+	}
 
-		// Determine what file/line info to show
-		var displayFile string
-		if cfg.ShowFullPath {
-			displayFile = file
+	if cfg.fromPanic || cfg.FromDefer {
+		frames = captureFrames(depth)
+		if cfg.fromPanic {
+			frames = panicSite(frames)
+		} else {
+			frames = deferSite(frames)
+		}
+		if cfg.SkipFrames < len(frames) {
+			frames = frames[cfg.SkipFrames:]
 		} else {
-			displayFile = filepath.Base(file)
+			frames = nil
+		}
+	} else {
+		frames = captureFrames(cfg.SkipFrames + depth)
+	}
+	if !cfg.IncludeSelf {
+		frames = elideSelf(frames)
+	}
+	if cfg.SymbolResolver != nil {
+		if r := resolveSymbols(frames, cfg.SymbolResolver); r != nil {
+			t.header = append(t.header, fmt.Sprintf("%s (panic while resolving symbols: %v)", unknown, r))
+		}
+	}
+	if cfg.OriginHeader && len(frames) > 0 {
+		if pkg := funcPackage(frames[0].Func); pkg != "" {
+			t.header = append([]string{"origin: " + pkg}, t.header...)
+		}
+	}
+	if cfg.RepoRelative && len(frames) > 0 {
+		t.cfg.repoRoot = repoRoot(frames[0].File)
+	}
+	t.total = len(frames)
+	if cfg.DepthRange != nil {
+		frames = depthRange(frames, cfg.DepthRange[0], cfg.DepthRange[1])
+	}
+	if len(cfg.SkipFileLines) > 0 {
+		frames = skipFileLines(frames, cfg.SkipFileLines)
+	}
+	if cfg.MaxFrames > 0 && len(frames) > cfg.MaxFrames {
+		frames = frames[:cfg.MaxFrames]
+	}
+	if cfg.SortFrames != nil {
+		sort.SliceStable(frames, func(i, j int) bool {
+			return cfg.SortFrames(frames[i], frames[j])
+		})
+	}
+	if cfg.ReverseOrder {
+		for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+			frames[i], frames[j] = frames[j], frames[i]
 		}
+	}
+	t.frames = frames
+	return t
+}
 
-		var frameHeader string
-		if cfg.ShowLineNumbers {
-			if cfg.IncludePC {
-				frameHeader = fmt.Sprintf("%s:%d (0x%x)", displayFile, line, pc)
-			} else {
-				frameHeader = fmt.Sprintf("%s:%d", displayFile, line)
+// render formats the trace as text including any configured header and trailer lines.
+func (t *trace) render() []byte {
+	cfg := t.cfg
+	out := renderFrames(t.frames, cfg, newSourceCache(cfg))
+	if cfg.OrderBanner {
+		top, bottom := cfg.OrderBannerText[0], cfg.OrderBannerText[1]
+		if cfg.ReverseOrder {
+			top, bottom = bottom, top
+		}
+		out = append([]byte("↑ "+top+cfg.FrameSeparator), out...)
+		out = append(out, cfg.FrameSeparator+"↓ "+bottom...)
+	}
+	if len(t.header) > 0 {
+		out = append([]byte(strings.Join(t.header, cfg.FrameSeparator)+cfg.FrameSeparator), out...)
+	}
+	if cfg.SummaryFooter {
+		out = append(out, fmt.Sprintf("%s(%d frames total, %d hidden)", cfg.FrameSeparator, t.total, t.total-len(t.frames))...)
+	}
+	if cfg.Timing {
+		out = append(out, fmt.Sprintf("%scaptured in %s", cfg.FrameSeparator, time.Since(t.start).Round(time.Microsecond))...)
+	}
+	return indentLines(out, cfg.GlobalIndent)
+}
+
+// indentLines prefixes every line of out, including the first, with prefix.
+func indentLines(out []byte, prefix string) []byte {
+	if prefix == "" {
+		return out
+	}
+	lines := bytes.Split(out, []byte{'\n'})
+	for i, line := range lines {
+		lines[i] = append([]byte(prefix), line...)
+	}
+	return bytes.Join(lines, []byte{'\n'})
+}
+
+// captureFrames walks the stack, skip 0 being the function that called captureFrames.
+func captureFrames(skip int) []Frame {
+	var frames []Frame
+	walkFrames(skip+1, func(f Frame) bool {
+		frames = append(frames, f)
+		return true
+	})
+	return frames
+}
+
+// Walk - calls fn for each frame of the live stack, innermost first, without building any strings.
+// Skip 0 starts at Walk itself, same as SkipFrames. Walking stops as soon as fn returns false,
+// frames beyond that point are never symbolized. Source is only read if fn calls Frame.Source.
+func Walk(skip int, fn func(Frame) bool) {
+	walkFrames(skip, fn)
+}
+
+// walkFrames walks the stack until fn returns false, skip 0 being the function that called walkFrames.
+func walkFrames(skip int, fn func(Frame) bool) {
+	pcs := make([]uintptr, 64)
+	for {
+		n := runtime.Callers(skip+2, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, len(pcs)*2)
+	}
+
+	callers := runtime.CallersFrames(pcs)
+	callee := ""
+	for {
+		f, more := callers.Next()
+		if f.PC != 0 || f.File != "" {
+			frame := Frame{
+				PC:      f.PC,
+				Func:    f.Function,
+				File:    f.File,
+				Line:    f.Line,
+				Inlined: f.Func == nil && f.Function != "", // the runtime only omits Func for inlined go frames
+				faulted: callee == "runtime.sigpanic",
 			}
-		} else {
-			if cfg.IncludePC {
-				frameHeader = fmt.Sprintf("%s (0x%x)", displayFile, pc)
-			} else {
-				frameHeader = displayFile
+			if !fn(frame) {
+				return
 			}
 		}
+		callee = f.Function
+		if !more {
+			return
+		}
+	}
+}
 
-		funcName := resolveFuncName(pc, cfg.ShortFuncNames)
-
-		var frameChunks []string
-		frameChunks = append(frameChunks, frameHeader)
+// panicSite drops the frames of the deferred recover and the runtime's panic machinery so the trace starts at
+// the frame that panicked. Frames are returned unchanged when no panic is in progress.
+func panicSite(frames []Frame) []Frame {
+	for i, f := range frames {
+		if f.Func != "runtime.gopanic" {
+			continue
+		}
+		frames = frames[i+1:]
+		// runtime errors such as nil dereferences pass through e.g. runtime.sigpanic and runtime.panicmem
+		for len(frames) > 0 && strings.HasPrefix(frames[0].Func, "runtime.") {
+			frames = frames[1:]
+		}
+		return frames
+	}
+	return frames
+}
 
-		if cfg.IncludeSourceCode {
-			if file != lastFile {
-				data, err := os.ReadFile(file)
-				if err == nil {
-					lines = bytes.Split(data, []byte{'\n'})
-					lastFile = file
-				} else {
-					lines = nil
+// deferSite drops the entry point, the deferred func calling it and the runtime's defer or panic machinery so the
+// trace starts at the function that registered the defer. It assumes exactly one defer layer, i.e. the entry
+// point is called directly from the deferred func.
+// While panicking the functions between the registering one and the panic are still on the stack, the registering
+// function is then found as the one the deferred closure is defined in. Deferring a named function gives no such
+// hint and the trace starts at the panic site instead.
+func deferSite(frames []Frame) []Frame {
+	if len(frames) < 2 {
+		return nil
+	}
+	deferred := frames[1].Func
+	frames = frames[2:]
+	// deferreturn on normal return, gopanic and friends while panicking, nothing for open-coded defers
+	panicking := false
+	for len(frames) > 0 && strings.HasPrefix(frames[0].Func, "runtime.") {
+		panicking = panicking || frames[0].Func == "runtime.gopanic"
+		frames = frames[1:]
+	}
+	if panicking {
+		if i := strings.LastIndexByte(deferred, '.'); i >= 0 && isClosureSegment([]byte(deferred[i+1:])) {
+			for j, f := range frames {
+				if f.Func == deferred[:i] {
+					return frames[j:]
 				}
 			}
-			code := source(lines, line)
-			frameChunks = append(frameChunks, fmt.Sprintf("%s%s: %s", cfg.ChunkIndentation, funcName, code))
-		} else {
-			frameChunks = append(frameChunks, fmt.Sprintf("%s%s", cfg.ChunkIndentation, funcName))
 		}
+	}
+	return frames
+}
+
+// elideSelf drops the frames of this package's own capture and formatting machinery, whatever SkipFrames counted.
+func elideSelf(frames []Frame) []Frame {
+	kept := frames[:0]
+	for _, f := range frames {
+		if funcPackage(f.Func) != selfPackage {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// depthRange returns frames[from:to+1], clamping out of range bounds.
+func depthRange(frames []Frame, from, to int) []Frame {
+	if from < 0 {
+		from = 0
+	}
+	if to >= len(frames) {
+		to = len(frames) - 1
+	}
+	if from > to {
+		return nil
+	}
+	return frames[from : to+1]
+}
+
+// resolveSymbols replaces the runtime's resolution of frames with r's wherever r accepts the PC.
+// A panicking resolver keeps the runtime's resolution for that frame, the first panic value is returned.
+func resolveSymbols(frames []Frame, r SymbolResolver) (recovered interface{}) {
+	for i := range frames {
+		if p := resolveSymbol(&frames[i], r); p != nil && recovered == nil {
+			recovered = p
+		}
+	}
+	return recovered
+}
 
-		frames = append(frames, strings.Join(frameChunks, cfg.ChunkSeparator))
+// resolveSymbol resolves a single frame through r, recovering and returning a panic in r.
+func resolveSymbol(f *Frame, r SymbolResolver) (recovered interface{}) {
+	defer func() {
+		recovered = recover()
+	}()
+	if name, file, line, ok := r.Resolve(f.PC); ok {
+		f.Func, f.File, f.Line = name, file, line
 	}
+	return nil
+}
+
+// maxTreeIndent caps the indentation levels added by WithTreeIndent so deep stacks don't run away.
+const maxTreeIndent = 16
+
+// renderFrames formats frames according to cfg, reading source through sources.
+// A panic while formatting, e.g. from a broken SourceFS, ends the trace with a note instead of propagating,
+// so it is safe to render from within a recover handler.
+func renderFrames(frames []Frame, cfg StackTraceConfig, sources *sourceCache) []byte {
+	var out []string
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				out = append(out, fmt.Sprintf("%s (panic while formatting trace: %v)", unknown, r))
+			}
+		}()
+		appendFrames(&out, frames, cfg, sources)
+	}()
 
 	// Join all frames with the configured frameSeparator
-	output := strings.Join(frames, cfg.FrameSeparator)
+	output := strings.Join(out, cfg.FrameSeparator)
 	return []byte(output)
 }
 
-// resolveFuncName returns the function name based on the config.
-func resolveFuncName(pc uintptr, shortNames bool) []byte {
-	fn := runtime.FuncForPC(pc)
-	if fn == nil {
-		return unknown
+// appendFrames appends the formatted frames to out one by one, so frames formatted before a panic are kept.
+func appendFrames(out *[]string, frames []Frame, cfg StackTraceConfig, sources *sourceCache) {
+	// when folding, frames are indented one level below their file header
+	var foldIndent string
+	if cfg.FoldByFile {
+		foldIndent = cfg.ChunkIndentation
+		cfg.ChunkIndentation += foldIndent
 	}
+	lastFile := ""
 
-	if shortNames {
-		name := []byte(fn.Name())
-		if lastSlash := bytes.LastIndex(name, slash); lastSlash >= 0 {
-			name = name[lastSlash+1:]
+	for i, f := range frames {
+		f.Func = displayFunc(cfg, f.Func)
+		if cfg.NativeFormat {
+			*out = append(*out, nativeFrame(f, cfg))
+			continue
 		}
-		name = bytes.ReplaceAll(name, centerDot, dot)
-		if period := bytes.Index(name, dot); period >= 0 {
-			name = name[period+1:]
+
+		// Determine what file/line info to show
+		displayFile := displayPath(cfg, f.File)
+
+		// frames parsed from a goroutine dump don't have a PC
+		includePC := cfg.IncludePC && f.PC != 0
+
+		funcName := resolveFuncName(f.Func, cfg.ShortFuncNames)
+		if cfg.QualifiedReceiver && cfg.ShortFuncNames {
+			funcName = qualifyReceiver(f.Func, funcName)
+		}
+		if cfg.StableClosureNames {
+			funcName = stableClosureName(funcName)
+		} else if cfg.EnclosingContext {
+			funcName = enclosingContext(funcName)
+		}
+		if cfg.ShowPackage {
+			if pkg := funcPackage(f.Func); pkg != "" {
+				funcName = append([]byte(pkg+" "), funcName...)
+			}
+		}
+		if cfg.MaxFuncNameLen > 0 {
+			funcName = truncateRunes(funcName, cfg.MaxFuncNameLen, cfg.TruncateFuncTail)
 		}
-		return name
-	}
 
-	return []byte(fn.Name())
-}
+		frameHeader := displayFile
+		if cfg.FoldByFile {
+			if i == 0 || f.File != lastFile {
+				*out = append(*out, displayFile)
+			}
+			lastFile = f.File
+			frameHeader = string(funcName)
+		}
+		if cfg.ShowLineNumbers {
+			frameHeader = fmt.Sprintf("%s:%d", frameHeader, f.Line)
+		}
+		// a link would carry the real path right next to its redacted text
+		if cfg.EditorLinks != "" && cfg.PathRedactor == nil {
+			frameHeader = editorLink(cfg.EditorLinks, f.File, f.Line, frameHeader)
+		}
+		frameHeader = foldIndent + frameHeader
+		if includePC {
+			frameHeader += " (" + formatPC(f.PC, cfg.PCFormat) + ")"
+		}
+		if cfg.PCOffset {
+			if offset, ok := pcOffset(f); ok {
+				frameHeader += fmt.Sprintf(" +0x%x", offset)
+			}
+		}
 
-// source returns a space-trimmed slice of the nth line.
-func source(lines [][]byte, n int) []byte {
-	n-- // stack traces are 1-indexed
-	if n < 0 || n >= len(lines) {
-		return unknown
-	}
-	return bytes.TrimSpace(lines[n])
-}
+		if cfg.MarkInlined && f.Inlined {
+			frameHeader += " (inlined)"
+		}
+		if cfg.PerFrameID {
+			frameHeader += " [" + f.ID() + "]"
+		}
+		if cfg.StableKey && f.Func != "" {
+			key := f.Func
+			if cfg.StableClosureNames {
+				key = string(stableClosureName([]byte(key)))
+			}
+			frameHeader += " key=" + key
+		}
+		if cfg.SourceMTime && cfg.IncludeSourceCode {
+			if modTime := sources.file(f.File).modTime; !modTime.IsZero() {
+				frameHeader += " mtime=" + modTime.Format(time.RFC3339)
+			}
+		}
+		// git reads the file as well, so only blame what WithSourceRoots and WithSourceReadBudget let through
+		if cfg.GitBlame && sources.lines(f.File) != nil {
+			frameHeader += gitBlame(f.File, f.Line)
+		}
+		if cfg.FunctionProgress {
+			frameHeader += functionProgress(f, sources)
+		}
+		// with origin colors the highlighting marks app frames instead
+		if cfg.MarkInApp && originColor(cfg, f.Func) == "" && classifyOrigin(f.Func, cfg.InAppPackages) == originApp {
+			frameHeader += " [app]"
+		}
+		if cfg.SourceStatus && cfg.IncludeSourceCode {
+			if f.Line >= 1 && f.Line <= len(sources.lines(f.File)) {
+				frameHeader += " [src:ok]"
+			} else {
+				frameHeader += " [src:missing]"
+			}
+		}
 
-var (
-	slash     = []byte("/")
-	dot       = []byte(".")
-	centerDot = []byte("·")
-	unknown   = []byte("???")
-)
+		var frameChunks []string
+		frameChunks = append(frameChunks, frameHeader)
 
-type StackTraceOption func(*StackTraceConfig)
+		before, after := cfg.sourceContextLines()
+		context := cfg.IncludeSourceCode && (before > 0 || after > 0)
+		if context {
+			context = f.Line >= 1 && f.Line <= len(sources.lines(f.File))
+		}
 
-func WithSkipFrames(skip int) StackTraceOption {
-	return func(cfg *StackTraceConfig) {
-		cfg.SkipFrames = skip
+		// when folding, the func is already part of the frame's entry line
+		if context {
+			if !cfg.FoldByFile {
+				frameChunks = append(frameChunks, fmt.Sprintf("%s%s:", cfg.ChunkIndentation, funcName))
+			}
+			frameChunks = append(frameChunks, sourceContext(sources.lines(f.File), f.Line, caretColumn(f, cfg), cfg)...)
+		} else if cfg.IncludeSourceCode {
+			code := sources.line(f.File, f.Line)
+			if cfg.StripComments {
+				code = stripLineComment(code)
+			}
+			format := cfg.ChunkFormat
+			if format == "" {
+				format = defaultChunkFormat
+			}
+			if cfg.FoldByFile {
+				format = "{indent}{code}"
+			}
+			// {code} is substituted last so placeholders inside the source itself are left alone
+			placeholders := strings.NewReplacer("{indent}", cfg.ChunkIndentation, "{func}", string(funcName))
+			before, after, hasCode := strings.Cut(format, "{code}")
+			prefix := placeholders.Replace(before)
+			if hasCode {
+				frameChunks = append(frameChunks, prefix+displayCode(code, cfg)+placeholders.Replace(after))
+			} else {
+				frameChunks = append(frameChunks, prefix)
+			}
+			if cfg.Caret && hasCode && !bytes.Equal(code, unknown) {
+				raw := sources.lines(f.File)[f.Line-1]
+				lead := len(raw) - len(bytes.TrimLeft(raw, " \t"))
+				frameChunks = append(frameChunks, caret(prefix, code, caretColumn(f, cfg)-lead))
+			}
+		} else if !cfg.FoldByFile {
+			frameChunks = append(frameChunks, fmt.Sprintf("%s%s", cfg.ChunkIndentation, funcName))
+		}
+		if cfg.SourceNotes && cfg.IncludeSourceCode {
+			for _, note := range sourceNotes(sources.lines(f.File), f.Line) {
+				frameChunks = append(frameChunks, cfg.ChunkIndentation+"note: "+note)
+			}
+		}
+		if cfg.PackageContext && cfg.IncludeSourceCode && i == 0 {
+			for _, line := range packageContext(sources.lines(f.File)) {
+				frameChunks = append(frameChunks, cfg.ChunkIndentation+line)
+			}
+		}
+
+		if cfg.TreeIndent {
+			level := i
+			if level > maxTreeIndent {
+				level = maxTreeIndent
+			}
+			indent := strings.Repeat(cfg.ChunkIndentation, level)
+			for c := range frameChunks {
+				frameChunks[c] = indent + frameChunks[c]
+			}
+		}
+
+		if cfg.WrapWidth > 0 {
+			// box connectors and the global indent are prepended to every line below
+			start := columns(cfg.GlobalIndent, 0)
+			if cfg.BoxDrawing {
+				start = columns(boxBranch, start)
+			}
+			var wrapped []string
+			for _, chunk := range frameChunks {
+				wrapped = append(wrapped, wrapLine(chunk, start, cfg.WrapWidth, cfg.ChunkIndentation)...)
+			}
+			frameChunks = wrapped
+		}
+
+		if cfg.BoxDrawing {
+			branch, pipe := boxBranch, boxPipe
+			if i == len(frames)-1 {
+				branch, pipe = boxLastBranch, boxSpace
+			}
+			if cfg.ASCIIOnly {
+				branch, pipe = asciiBranch, asciiPipe
+				if i == len(frames)-1 {
+					branch, pipe = asciiLastBranch, boxSpace
+				}
+			}
+			for c := range frameChunks {
+				if c == 0 {
+					frameChunks[c] = branch + frameChunks[c]
+				} else {
+					frameChunks[c] = pipe + frameChunks[c]
+				}
+			}
+		}
+
+		if color := originColor(cfg, f.Func); color != "" {
+			for c := range frameChunks {
+				frameChunks[c] = color + frameChunks[c] + colorReset
+			}
+		}
+
+		*out = append(*out, strings.Join(frameChunks, cfg.ChunkSeparator))
 	}
+
 }
 
-func WithIncludeSourceCode(include bool) StackTraceOption {
-	return func(cfg *StackTraceConfig) {
-		cfg.IncludeSourceCode = include
+// displayPath returns file as it should be rendered according to cfg.
+func displayPath(cfg StackTraceConfig, file string) string {
+	if cfg.NormalizePaths {
+		file = normalizeWindowsPath(file)
+	}
+	if cfg.repoRoot != "" {
+		if rel, err := filepath.Rel(cfg.repoRoot, file); err == nil && !strings.HasPrefix(rel, "..") {
+			file = rel
+		}
+	}
+	if cfg.UnvendorPaths {
+		file = unvendor(file)
 	}
+	if !cfg.ShowFullPath {
+		file = filepath.Base(file)
+	}
+	if cfg.PathRedactor != nil {
+		file = cfg.PathRedactor(file)
+	}
+	return file
 }
 
-func WithIncludePC(include bool) StackTraceOption {
-	return func(cfg *StackTraceConfig) {
-		cfg.IncludePC = include
+// displayFunc returns the full func name as it should be rendered according to cfg, before any shortening.
+func displayFunc(cfg StackTraceConfig, fullName string) string {
+	if cfg.UnvendorPaths {
+		return unvendor(fullName)
 	}
+	return fullName
 }
 
-func WithShortFuncNames(short bool) StackTraceOption {
-	return func(cfg *StackTraceConfig) {
-		cfg.ShortFuncNames = short
+// nativeFrame renders f the way the runtime prints stacks, "pkg.Func(...)\n\tfile:line +0x1d". Arguments aren't
+// known. Like in the runtime's output inlined frames have no offset, neither do frames without a PC.
+func nativeFrame(f Frame, cfg StackTraceConfig) string {
+	name := f.Func
+	if name == "" {
+		name = string(unknown)
 	}
+	frame := fmt.Sprintf("%s(...)\n\t%s:%d", name, displayPath(cfg, f.File), f.Line)
+	if offset, ok := pcOffset(f); ok && !f.Inlined {
+		frame += fmt.Sprintf(" +0x%x", offset)
+	}
+	return frame
 }
 
-func WithShowFullPath(full bool) StackTraceOption {
-	return func(cfg *StackTraceConfig) {
-		cfg.ShowFullPath = full
+// pcOffset returns the offset into its function the runtime prints for f, ok is false when there is none.
+// CallersFrames reports the call instruction, one before the return address the runtime prints, except for a
+// frame interrupted by a signal panic whose PC is exact.
+func pcOffset(f Frame) (uintptr, bool) {
+	fn := runtime.FuncForPC(f.PC)
+	if f.PC == 0 || fn == nil {
+		return 0, false
 	}
+	pc := f.PC
+	if !f.faulted {
+		pc++
+	}
+	if pc <= fn.Entry() {
+		return 0, false
+	}
+	return pc - fn.Entry(), true
 }
 
-func WithShowLineNumbers(show bool) StackTraceOption {
-	return func(cfg *StackTraceConfig) {
-		cfg.ShowLineNumbers = show
+// currentGoroutineID returns the id of the calling goroutine parsed from the header of its own stack dump.
+func currentGoroutineID() (int64, bool) {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	g, ok := parseGoroutineHeader(string(bytes.SplitN(buf, []byte{'\n'}, 2)[0]))
+	return g.ID, ok
+}
+
+// sourceNoteMarker starts a comment surfaced by WithSourceNotes.
+const sourceNoteMarker = "//traceutils:note"
+
+// sourceNotes returns the texts of the note comments on line n and the line above it.
+func sourceNotes(lines [][]byte, n int) []string {
+	var notes []string
+	for i := n - 1; i <= n; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		line := string(lines[i-1])
+		if at := strings.Index(line, sourceNoteMarker); at >= 0 {
+			if note := strings.TrimSpace(line[at+len(sourceNoteMarker):]); note != "" {
+				notes = append(notes, note)
+			}
+		}
 	}
+	return notes
 }
 
-func WithFrameSeparator(frameSeparator string) StackTraceOption {
-	return func(cfg *StackTraceConfig) {
-		cfg.FrameSeparator = frameSeparator
+// maxPackageContextImports caps the imports packageContext shows.
+const maxPackageContextImports = 10
+
+// packageContext returns the package clause and the import declarations at the top of a go source file,
+// nil when there is no package clause.
+func packageContext(lines [][]byte) []string {
+	var out []string
+	imports := 0
+	inBlock := false
+	for _, raw := range lines {
+		line := string(bytes.TrimSpace(raw))
+		switch {
+		case inBlock && line == ")":
+			out = append(out, line)
+			inBlock = false
+		case inBlock:
+			if line == "" || strings.HasPrefix(line, "//") {
+				continue
+			}
+			imports++
+			if imports == maxPackageContextImports+1 {
+				out = append(out, "\t...")
+			} else if imports <= maxPackageContextImports {
+				out = append(out, "\t"+line)
+			}
+		case strings.HasPrefix(line, "package "):
+			out = append(out, line)
+		case line == "import (":
+			out = append(out, line)
+			inBlock = true
+		case strings.HasPrefix(line, "import "):
+			out = append(out, line)
+		case len(out) > 0 && line != "" && !strings.HasPrefix(line, "//"):
+			// the first declaration ends the file header
+			return out
+		}
 	}
+	return out
 }
 
-func WithChunkSeparator(chunkSeparator string) StackTraceOption {
-	return func(cfg *StackTraceConfig) {
-		cfg.ChunkSeparator = chunkSeparator
+// formatPC renders pc according to format, see WithPCFormat.
+func formatPC(pc uintptr, format string) string {
+	switch format {
+	case "decimal":
+		return strconv.FormatUint(uint64(pc), 10)
+	case "", "hex":
+	default:
+		if validPCFormat(format) {
+			return fmt.Sprintf(format, pc)
+		}
 	}
+	return "0x" + strconv.FormatUint(uint64(pc), 16)
 }
 
-func WithChunkIndentation(chunkIndentation string) StackTraceOption {
-	return func(cfg *StackTraceConfig) {
+// validPCFormat reports whether format is a single integer verb such as "%d", "%#x" or "%016X".
+func validPCFormat(format string) bool {
+	if len(format) < 2 || format[0] != '%' {
+		return false
+	}
+	i := 1
+	for i < len(format)-1 && strings.IndexByte("#0+- ", format[i]) >= 0 {
+		i++
+	}
+	for i < len(format)-1 && format[i] >= '0' && format[i] <= '9' {
+		i++
+	}
+	return i == len(format)-1 && strings.IndexByte("bdoOxX", format[i]) >= 0
+}
+
+// skipFileLines drops the frames falling into any of rules.
+func skipFileLines(frames []Frame, rules []FileLines) []Frame {
+	kept := frames[:0]
+	for _, f := range frames {
+		skip := false
+		for _, rule := range rules {
+			if strings.HasSuffix(f.File, rule.File) && f.Line >= rule.From && f.Line <= rule.To {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// repoRoot returns the closest directory above file holding a .git directory or a go.mod, empty if there is none.
+func repoRoot(file string) string {
+	if file == "" || !filepath.IsAbs(file) {
+		return ""
+	}
+	for dir := filepath.Dir(file); ; {
+		for _, marker := range []string{".git", "go.mod"} {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// sourceCache reads each file at most once per capture, remembering failed reads too.
+// It is safe for concurrent use, concurrent lookups of the same file wait for a single read.
+type sourceCache struct {
+	cfg   StackTraceConfig
+	mu    sync.Mutex
+	files map[string]*sourceFile
+	read  int64 // bytes read so far, checked against cfg.SourceReadBudget
+}
+
+type sourceFile struct {
+	once    sync.Once
+	lines   [][]byte
+	modTime time.Time // only set with cfg.SourceMTime
+}
+
+func newSourceCache(cfg StackTraceConfig) *sourceCache {
+	return &sourceCache{cfg: cfg, files: map[string]*sourceFile{}}
+}
+
+// lines returns the lines of file, nil when it couldn't be read.
+func (c *sourceCache) lines(file string) [][]byte {
+	return c.file(file).lines
+}
+
+// file returns the cache entry of file, loading it on first use.
+func (c *sourceCache) file(file string) *sourceFile {
+	c.mu.Lock()
+	sf, ok := c.files[file]
+	if !ok {
+		sf = &sourceFile{}
+		c.files[file] = sf
+	}
+	c.mu.Unlock()
+
+	sf.once.Do(func() {
+		sf.lines = c.load(file)
+		if sf.lines != nil && c.cfg.SourceMTime {
+			if info, err := statSource(c.cfg, file); err == nil {
+				sf.modTime = info.ModTime()
+			}
+		}
+		if c.cfg.OnFileLoad != nil {
+			// WithParallelism loads files concurrently, the callback must not have to care
+			c.mu.Lock()
+			c.cfg.OnFileLoad(file, sf.lines != nil)
+			c.mu.Unlock()
+		}
+	})
+	return sf
+}
+
+// load reads and splits file, nil when it can't be read or the read budget is exhausted.
+func (c *sourceCache) load(file string) [][]byte {
+	if c.overBudget() {
+		return nil
+	}
+	data, err := readSource(c.cfg, file)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.read += int64(len(data))
+	c.mu.Unlock()
+	return bytes.Split(data, []byte{'\n'})
+}
+
+// overBudget reports whether reading another file would exceed cfg.SourceReadBudget.
+func (c *sourceCache) overBudget() bool {
+	if c.cfg.SourceReadBudget <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.read > c.cfg.SourceReadBudget
+}
+
+// line returns the space-trimmed nth line of file or ??? when unavailable.
+func (c *sourceCache) line(file string, n int) []byte {
+	return source(c.lines(file), n)
+}
+
+// normalizeWindowsPath converts a windows path such as `C:\Users\me\main.go` to "c:/Users/me/main.go",
+// other paths are returned unchanged.
+func normalizeWindowsPath(file string) string {
+	drive := len(file) >= 3 && file[1] == ':' && (file[2] == '\\' || file[2] == '/') &&
+		('a' <= file[0] && file[0] <= 'z' || 'A' <= file[0] && file[0] <= 'Z')
+	unc := strings.HasPrefix(file, `\\`)
+	if !drive && !unc {
+		return file
+	}
+
+	file = strings.ReplaceAll(file, `\`, "/")
+	if drive {
+		file = strings.ToLower(file[:1]) + file[1:]
+	}
+	return file
+}
+
+// editorLink wraps text in an OSC-8 terminal hyperlink opening file at line in the editor described by scheme.
+func editorLink(scheme, file string, line int, text string) string {
+	var url string
+	switch scheme {
+	case "vscode":
+		url = fmt.Sprintf("vscode://file/%s:%d", strings.TrimPrefix(filepath.ToSlash(file), "/"), line)
+	case "idea":
+		url = fmt.Sprintf("idea://open?file=%s&line=%d", filepath.ToSlash(file), line)
+	default:
+		url = strings.NewReplacer("{file}", filepath.ToSlash(file), "{line}", strconv.Itoa(line)).Replace(scheme)
+	}
+	return "\x1b]8;;" + url + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
+
+// readSource loads file either from disk or, when configured, from cfg.SourceFS.
+func readSource(cfg StackTraceConfig, file string) ([]byte, error) {
+	if len(cfg.SourceRoots) > 0 && !underRoots(file, cfg.SourceRoots) {
+		return nil, fs.ErrPermission
+	}
+	if cfg.SourceFS == nil {
+		return os.ReadFile(file)
+	}
+
+	name, err := sourceFSName(cfg, file)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(cfg.SourceFS, name)
+}
+
+// statSource stats file wherever readSource would read it from.
+func statSource(cfg StackTraceConfig, file string) (fs.FileInfo, error) {
+	if cfg.SourceFS == nil {
+		return os.Stat(file)
+	}
+
+	name, err := sourceFSName(cfg, file)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(cfg.SourceFS, name)
+}
+
+// sourceFSName maps a recorded file path onto cfg.SourceFS.
+func sourceFSName(cfg StackTraceConfig, file string) (string, error) {
+	name := strings.TrimPrefix(filepath.ToSlash(file), filepath.ToSlash(cfg.SourcePrefix))
+	name = strings.TrimPrefix(name, "/")
+	if !fs.ValidPath(name) {
+		return "", fs.ErrNotExist
+	}
+	return name, nil
+}
+
+// underRoots reports whether file is located inside one of roots.
+func underRoots(file string, roots []string) bool {
+	file = filepath.Clean(file)
+	for _, root := range roots {
+		rel, err := filepath.Rel(filepath.Clean(root), file)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveFuncName returns the function name based on the config.
+func resolveFuncName(fullName string, shortNames bool) []byte {
+	if fullName == "" {
+		return unknown
+	}
+
+	if shortNames {
+		name := []byte(fullName)
+		if lastSlash := bytes.LastIndex(name, slash); lastSlash >= 0 {
+			name = name[lastSlash+1:]
+		}
+		name = bytes.ReplaceAll(name, centerDot, dot)
+		if period := bytes.Index(name, dot); period >= 0 {
+			name = name[period+1:]
+		}
+		return name
+	}
+
+	return []byte(fullName)
+}
+
+// qualifyReceiver prefixes the short name of a method with the last element of its package path, e.g.
+// "(*Type).Method" of "github.com/x/pkg.(*Type).Method" becomes "pkg.(*Type).Method". Other funcs are left as is.
+func qualifyReceiver(fullName string, short []byte) []byte {
+	// type arguments may contain dots of their own
+	var plain []byte
+	depth := 0
+	for _, c := range short {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			plain = append(plain, c)
+		}
+	}
+	segments := bytes.Split(plain, dot)
+	method := bytes.HasPrefix(plain, []byte("(")) || (len(segments) > 1 && !isClosureSegment(segments[1]))
+	pkg := funcPackage(fullName)
+	if !method || pkg == "" {
+		return short
+	}
+	pkg = pkg[strings.LastIndexByte(pkg, '/')+1:]
+	return append([]byte(pkg+"."), short...)
+}
+
+// enclosingContext renders closures as their enclosing named function followed by the closure path in brackets,
+// e.g. "(*Handler).ServeHTTP.func1.2" becomes "(*Handler).ServeHTTP[func1.2]".
+func enclosingContext(name []byte) []byte {
+	segments := bytes.Split(name, dot)
+	i := len(segments)
+	for i > 1 && isClosureSegment(segments[i-1]) {
+		i--
+	}
+	if i == len(segments) {
+		return name
+	}
+
+	out := bytes.Join(segments[:i], dot)
+	out = append(out, '[')
+	out = append(out, bytes.Join(segments[i:], dot)...)
+	return append(out, ']')
+}
+
+// stableClosureName renders closures uniformly as their enclosing named function followed by ".<closure>",
+// e.g. both "Serve.func1" and "Serve.func2.1" become "Serve.<closure>".
+func stableClosureName(name []byte) []byte {
+	segments := bytes.Split(name, dot)
+	i := len(segments)
+	for i > 1 && isClosureSegment(segments[i-1]) {
+		i--
+	}
+	if i == len(segments) {
+		return name
+	}
+	return append(bytes.Join(segments[:i], dot), ".<closure>"...)
+}
+
+// isClosureSegment reports whether segment is a compiler generated closure name such as func1, gowrap2 or,
+// for nested closures, a bare number.
+func isClosureSegment(segment []byte) bool {
+	for _, prefix := range closurePrefixes {
+		if bytes.HasPrefix(segment, prefix) {
+			segment = segment[len(prefix):]
+			break
+		}
+	}
+	if len(segment) == 0 {
+		return false
+	}
+	for _, c := range segment {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+var closurePrefixes = [][]byte{[]byte("func"), []byte("gowrap"), []byte("deferwrap")}
+
+// truncateRunes shortens s to n runes including a "…", keeping the tail instead of the head when keepTail is set.
+func truncateRunes(s []byte, n int, keepTail bool) []byte {
+	runes := bytes.Runes(s)
+	if len(runes) <= n {
+		return s
+	}
+	if keepTail {
+		return []byte("…" + string(runes[len(runes)-n+1:]))
+	}
+	return []byte(string(runes[:n-1]) + "…")
+}
+
+// unvendor strips everything up to and including a vendor directory from a file path or full func name,
+// e.g. "myapp/vendor/github.com/foo/bar.Baz" becomes "github.com/foo/bar.Baz".
+func unvendor(path string) string {
+	// generic type arguments may contain paths of their own
+	end := len(path)
+	if bracket := strings.IndexByte(path, '['); bracket >= 0 {
+		end = bracket
+	}
+	if i := strings.LastIndex(path[:end], "/vendor/"); i >= 0 {
+		return path[i+len("/vendor/"):]
+	}
+	return strings.TrimPrefix(path, "vendor/")
+}
+
+// funcPackage returns the package import path of a full runtime func name,
+// e.g. "myapp/server" for "myapp/server.(*Handler).Serve".
+func funcPackage(fullName string) string {
+	// generic type arguments may contain dots and slashes of their own
+	name := fullName
+	if bracket := strings.IndexByte(name, '['); bracket >= 0 {
+		name = name[:bracket]
+	}
+	pathEnd := strings.LastIndexByte(name, '/') + 1
+	period := strings.IndexByte(name[pathEnd:], '.')
+	if period < 0 {
+		return ""
+	}
+	return name[:pathEnd+period]
+}
+
+// source returns a space-trimmed slice of the nth line.
+func source(lines [][]byte, n int) []byte {
+	n-- // stack traces are 1-indexed
+	if n < 0 || n >= len(lines) {
+		return unknown
+	}
+	return bytes.TrimSpace(lines[n])
+}
+
+// sourceContext returns the lines around the nth line of lines, the nth line marked with ">".
+// With cfg.Caret a caret line follows the nth line, pointing at byte column caretCol or, when negative,
+// at the first non-whitespace character.
+func sourceContext(lines [][]byte, n int, caretCol int, cfg StackTraceConfig) []string {
+	before, after := cfg.sourceContextLines()
+	from, to := n-before, n+after
+	if from < 1 {
+		from = 1
+	}
+	if to > len(lines) {
+		to = len(lines)
+	}
+
+	window := make([][]byte, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		code := bytes.TrimRight(lines[i-1], " \t\r")
+		if cfg.StripComments {
+			code = stripLineComment(code)
+		}
+		window = append(window, code)
+	}
+	removed := 0
+	if cfg.DedentSource {
+		window, removed = dedent(window)
+	}
+
+	width := len(strconv.Itoa(to))
+	out := make([]string, 0, len(window)+1)
+	for i, code := range window {
+		marker := "  "
+		if from+i == n {
+			marker = "> "
+		}
+		out = append(out, fmt.Sprintf("%s%s%*d  %s", cfg.ChunkIndentation, marker, width, from+i, displayCode(code, cfg)))
+		if cfg.Caret && from+i == n {
+			if caretCol < 0 {
+				caretCol = len(code) - len(bytes.TrimLeft(code, " \t")) + removed
+			}
+			out = append(out, caret(fmt.Sprintf("%s  %*d  ", cfg.ChunkIndentation, width, n), code, caretCol-removed))
+		}
+	}
+	return out
+}
+
+// sourceContextLines returns how many lines sourceContext shows before and after a frame's line, the asymmetric
+// SourceContextBefore/After taking precedence over SourceContext when either is set.
+func (cfg StackTraceConfig) sourceContextLines() (before, after int) {
+	if cfg.SourceContextBefore > 0 || cfg.SourceContextAfter > 0 {
+		return cfg.SourceContextBefore, cfg.SourceContextAfter
+	}
+	return cfg.SourceContext, cfg.SourceContext
+}
+
+// displayCode returns a source line as rendered, shortened to cfg.MaxSourceLineLen runes with a note of how many
+// were left out, e.g. "code… (+120 chars)", and syntax highlighted with cfg.SyntaxHighlight.
+func displayCode(code []byte, cfg StackTraceConfig) string {
+	omitted := 0
+	if cfg.MaxSourceLineLen > 0 {
+		if runes := bytes.Runes(code); len(runes) > cfg.MaxSourceLineLen {
+			omitted = len(runes) - cfg.MaxSourceLineLen
+			code = []byte(string(runes[:cfg.MaxSourceLineLen]))
+		}
+	}
+
+	out := string(code)
+	if cfg.SyntaxHighlight && !colorDisabled() && !bytes.Equal(code, unknown) {
+		out = highlightGo(code, cfg.ColorScheme)
+	}
+	if omitted > 0 {
+		out += "… (+" + strconv.Itoa(omitted) + " chars)"
+	}
+	return out
+}
+
+// caretColumn returns the 0-based byte column cfg.CaretColumn reports for f, -1 when unknown.
+func caretColumn(f Frame, cfg StackTraceConfig) int {
+	if cfg.CaretColumn != nil {
+		if col := cfg.CaretColumn(f); col > 0 {
+			return col - 1
+		}
+	}
+	return -1
+}
+
+// caret returns a line pointing "^" at code[col] when rendered below prefix+code, a negative col points at the
+// first non-whitespace character. Tabs are kept so the caret lines up regardless of tab width.
+func caret(prefix string, code []byte, col int) string {
+	if col < 0 {
+		col = len(code) - len(bytes.TrimLeft(code, " \t"))
+	}
+	if col > len(code) {
+		col = len(code)
+	}
+	pad := []rune(prefix + string(code[:col]))
+	for i, r := range pad {
+		if r != '\t' {
+			pad[i] = ' '
+		}
+	}
+	return string(pad) + "^"
+}
+
+// dedent removes the leading whitespace common to all non-blank lines, preserving relative indentation.
+// Tabs and spaces are compared as-is so mixed indentation is never partially removed.
+// It also returns the number of bytes removed from each line.
+func dedent(lines [][]byte) ([][]byte, int) {
+	var common []byte
+	first := true
+	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		indent := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+		if first {
+			common, first = indent, false
+			continue
+		}
+		i := 0
+		for i < len(common) && i < len(indent) && common[i] == indent[i] {
+			i++
+		}
+		common = common[:i]
+	}
+
+	out := make([][]byte, len(lines))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			out[i] = nil
+			continue
+		}
+		out[i] = line[len(common):]
+	}
+	return out, len(common)
+}
+
+// functionProgress describes where f.Line lies within its function, e.g. " (line 42 of function spanning 30..60)".
+// The entry line comes from the runtime, the end is found by brace matching when source is available.
+func functionProgress(f Frame, sources *sourceCache) string {
+	fn := runtime.FuncForPC(f.PC)
+	// inlined frames report the entry of the function they were inlined into
+	if f.PC == 0 || f.Inlined || fn == nil {
+		return ""
+	}
+	_, entry := fn.FileLine(fn.Entry())
+	if entry <= 0 || entry > f.Line {
+		return ""
+	}
+
+	end, truncated := functionEnd(sources.lines(f.File), entry, sources.cfg.MaxFunctionLines)
+	switch {
+	case end >= f.Line && truncated:
+		return fmt.Sprintf(" (line %d of function spanning %d..%d...)", f.Line, entry, end)
+	case end >= f.Line:
+		return fmt.Sprintf(" (line %d of function spanning %d..%d)", f.Line, entry, end)
+	}
+	return fmt.Sprintf(" (line %d of function starting at %d)", f.Line, entry)
+}
+
+// connectors drawn by WithBoxDrawing, in Unicode and WithASCIIOnly flavors
+const (
+	boxBranch       = "├─ "
+	boxLastBranch   = "└─ "
+	boxPipe         = "│  "
+	boxSpace        = "   "
+	asciiBranch     = "|- "
+	asciiLastBranch = "`- "
+	asciiPipe       = "|  "
+)
+
+// defaultChunkFormat is the layout of the func and source line unless configured otherwise.
+const defaultChunkFormat = "{indent}{func}: {code}"
+
+// defaultMaxFunctionLines bounds how far functionEnd looks for the closing brace unless configured otherwise.
+const defaultMaxFunctionLines = 200
+
+// functionEnd returns the line closing the function declared on line entry by matching braces, scanning at most
+// max lines. When the closing brace isn't found within max lines it returns the last line scanned and truncated,
+// when the source ends first it returns 0.
+func functionEnd(lines [][]byte, entry, max int) (end int, truncated bool) {
+	if max <= 0 {
+		max = defaultMaxFunctionLines
+	}
+	depth, opened := 0, false
+	for n := entry; n <= len(lines); n++ {
+		if n >= entry+max {
+			return n - 1, true
+		}
+		opens, closes := countBraces(lines[n-1])
+		depth += opens - closes
+		opened = opened || opens > 0
+		if opened && depth <= 0 {
+			return n, false
+		}
+	}
+	return 0, false
+}
+
+// countBraces counts the curly braces of a single line of go source outside of literals and comments.
+func countBraces(line []byte) (opens, closes int) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && c == '/' && i+1 < len(line) && line[i+1] == '/':
+			return opens, closes
+		case quote == 0 && (c == '"' || c == '`' || c == '\''):
+			quote = c
+		case quote == 0 && c == '{':
+			opens++
+		case quote == 0 && c == '}':
+			closes++
+		case quote != 0 && quote != '`' && c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+		}
+	}
+	return opens, closes
+}
+
+// stripLineComment removes a trailing // comment from a single line of go source, ignoring // inside
+// string and rune literals. This is best-effort lexing, e.g. a raw string opened on a previous line isn't known.
+func stripLineComment(line []byte) []byte {
+	var quote byte // the literal we're inside of, 0 when in code
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && c == '/' && i+1 < len(line) && line[i+1] == '/':
+			return bytes.TrimRight(line[:i], " \t")
+		case quote == 0 && (c == '"' || c == '`' || c == '\''):
+			quote = c
+		case quote != 0 && quote != '`' && c == '\\':
+			i++ // skip the escaped character
+		case c == quote:
+			quote = 0
+		}
+	}
+	return line
+}
+
+// startTime approximates process start for WithUptime.
+var startTime = time.Now()
+
+// selfPackage is the import path of this package, see WithIncludeSelf.
+var selfPackage = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	return funcPackage(runtime.FuncForPC(pc).Name())
+}()
+
+// Shared by all concurrent captures and never written to. Capacity is capped at the length so an append to a
+// returned unknown always copies instead of writing into the shared backing array.
+var (
+	slash     = fullSlice([]byte("/"))
+	dot       = fullSlice([]byte("."))
+	centerDot = fullSlice([]byte("·"))
+	unknown   = fullSlice([]byte("???"))
+)
+
+func fullSlice(b []byte) []byte {
+	return b[:len(b):len(b)]
+}
+
+type StackTraceOption func(*StackTraceConfig)
+
+func WithSkipFrames(skip int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SkipFrames = skip
+	}
+}
+
+func WithIncludeSourceCode(include bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.IncludeSourceCode = include
+	}
+}
+
+func WithIncludePC(include bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.IncludePC = include
+	}
+}
+
+func WithShortFuncNames(short bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ShortFuncNames = short
+	}
+}
+
+func WithShowFullPath(full bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ShowFullPath = full
+	}
+}
+
+func WithShowLineNumbers(show bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ShowLineNumbers = show
+	}
+}
+
+func WithFrameSeparator(frameSeparator string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.FrameSeparator = frameSeparator
+	}
+}
+
+func WithChunkSeparator(chunkSeparator string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ChunkSeparator = chunkSeparator
+	}
+}
+
+func WithChunkIndentation(chunkIndentation string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
 		cfg.ChunkIndentation = chunkIndentation
 	}
 }
+
+// WithSortFrames reorders frames with less before rendering, e.g. by file then line when grouping.
+// This breaks call-order semantics and is intended for analysis views, not normal traces.
+func WithSortFrames(less func(a, b Frame) bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SortFrames = less
+	}
+}
+
+// WithSourceFS reads source code from fsys instead of the local disk, recorded paths are looked up without their leading slash.
+func WithSourceFS(fsys fs.FS) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SourceFS = fsys
+	}
+}
+
+// WithEmbeddedSources reads source code from sources shipped inside the binary, stripPrefix is removed from
+// the recorded build paths to map them onto efs. Frames whose mapped path isn't embedded render as ???.
+func WithEmbeddedSources(efs embed.FS, stripPrefix string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SourceFS = efs
+		cfg.SourcePrefix = stripPrefix
+	}
+}
+
+// WithMarkInlined appends (inlined) to frames the runtime reports as inlined into their caller,
+// explaining why their line doesn't match a separate PC.
+func WithMarkInlined(mark bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.MarkInlined = mark
+	}
+}
+
+// WithPathRedactor applies fn to every rendered file path, allowing arbitrary transformation such as hashing
+// or masking internal project names. Source is still read from the original path.
+func WithPathRedactor(fn func(path string) string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.PathRedactor = fn
+	}
+}
+
+// WithPerFrameID appends each frame's short ID so operators can reference a single frame across tools.
+func WithPerFrameID(show bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.PerFrameID = show
+	}
+}
+
+// WithMaxFrames limits the trace to the first max frames after skipping, zero means no limit.
+func WithMaxFrames(max int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.MaxFrames = max
+	}
+}
+
+// WithShowPackage prefixes the func name with its package import path, e.g. "myapp/server (*Handler).Serve".
+func WithShowPackage(show bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ShowPackage = show
+	}
+}
+
+// WithSourceRoots only reads source for files under one of roots, others render without source.
+// This hardens IncludeSourceCode when recorded paths may be attacker-influenced, no roots reads any file.
+func WithSourceRoots(roots ...string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SourceRoots = roots
+	}
+}
+
+// WithTiming appends a trailer line with how long capturing and formatting took, e.g. "captured in 1.2ms",
+// to help judge whether options such as IncludeSourceCode are too expensive for a hot path.
+func WithTiming(timing bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.Timing = timing
+	}
+}
+
+// WithStripComments removes // line comments from displayed source lines, aimed at dense log output.
+func WithStripComments(strip bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.StripComments = strip
+	}
+}
+
+// WithSummaryFooter appends a footer tallying captured vs hidden frames, e.g. "(12 frames total, 5 hidden)",
+// so readers know what was elided by limits and filters.
+func WithSummaryFooter(footer bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SummaryFooter = footer
+	}
+}
+
+// WithGoroutineCount adds a "goroutines=N" header line with runtime.NumGoroutine at capture time.
+func WithGoroutineCount(count bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.GoroutineCount = count
+	}
+}
+
+// WithEnclosingContext shows closures as their enclosing named function with the closure in brackets,
+// e.g. "ServeHTTP[func1]", instead of the bare "func1".
+func WithEnclosingContext(show bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.EnclosingContext = show
+	}
+}
+
+// WithParallelism renders up to n goroutine blocks of NewAllGoroutinesTrace concurrently, output order is preserved.
+func WithParallelism(n int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.Parallelism = n
+	}
+}
+
+// WithHeader prepends lines to the trace such as "level=panic" or a custom label,
+// each separated by FrameSeparator.
+func WithHeader(lines ...string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.Header = lines
+	}
+}
+
+// WithSourceContext shows n lines of source before and after each frame's line, the frame's line marked with ">".
+func WithSourceContext(n int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SourceContext = n
+	}
+}
+
+// WithDedentSource removes the indentation common to the lines shown by WithSourceContext,
+// keeping deeply nested code readable in narrow log viewers.
+func WithDedentSource(dedent bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.DedentSource = dedent
+	}
+}
+
+// WithEditorLinks wraps each file:line in an OSC-8 terminal hyperlink so modern terminals can jump to the source.
+// scheme is "vscode", "idea" or a url template such as "file://{file}" using {file} and {line} placeholders,
+// an empty scheme renders plain text. No links are emitted together with WithPathRedactor.
+func WithEditorLinks(scheme string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.EditorLinks = scheme
+	}
+}
+
+// WithReverseOrder renders frames oldest-first, starting at the entry point and ending at the innermost frame.
+func WithReverseOrder(reverse bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ReverseOrder = reverse
+	}
+}
+
+// WithOrderBanner surrounds the frames with banners naming each end of the stack, "↑ panic site" / "↓ entry point",
+// swapped when combined with WithReverseOrder, so readers never have to guess the ordering.
+func WithOrderBanner(banner bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.OrderBanner = banner
+	}
+}
+
+// WithOrderBannerText replaces the default "panic site" and "entry point" banner texts.
+func WithOrderBannerText(innermost, outermost string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.OrderBannerText = [2]string{innermost, outermost}
+	}
+}
+
+// WithFunctionProgress shows how far into its function each frame's line is, e.g. "(line 42 of function spanning 30..60)".
+// The entry line is always known for live frames, the end is best-effort and needs source.
+func WithFunctionProgress(progress bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.FunctionProgress = progress
+	}
+}
+
+// WithSymbolResolver resolves frames through r instead of the runtime wherever r accepts the PC,
+// e.g. to integrate deobfuscation tooling such as garble maps.
+func WithSymbolResolver(r SymbolResolver) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SymbolResolver = r
+	}
+}
+
+// WithSourceReadBudget stops reading new source files once maxBytes have been read, subsequent frames render
+// without source. The budget resets per capture, files already read are cached and don't count against it again.
+func WithSourceReadBudget(maxBytes int64) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SourceReadBudget = maxBytes
+	}
+}
+
+// WithUptime adds an "uptime=..." header line with the time since the process started,
+// useful to correlate crashes with process lifetime in long-running daemons.
+func WithUptime(uptime bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.Uptime = uptime
+	}
+}
+
+// WithDepthRange renders only frames from through to, inclusive, e.g. 2 through 6. Indexes count after
+// SkipFrames but before any other filtering, out of range bounds are clamped.
+func WithDepthRange(from, to int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.DepthRange = []int{from, to}
+	}
+}
+
+// fromPanic makes the trace start at the site of the panic being recovered, SkipFrames then counts from there.
+func fromPanic() StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.fromPanic = true
+	}
+}
+
+// WithLogSink sets where Handler logs recovered panics, the standard logger is used when w is nil.
+func WithLogSink(w io.Writer) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.LogSink = w
+	}
+}
+
+// WithSourceStatus marks each frame with [src:ok] or [src:missing], e.g. to notice a production image lacks
+// source files. Markers are only shown when IncludeSourceCode is on.
+func WithSourceStatus(status bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SourceStatus = status
+	}
+}
+
+// WithPCOffset shows each frame's PC as an offset from its function's entry, e.g. "+0x1d", matching the runtime's
+// own stack traces which give the return address. Unlike the absolute PC the offset is stable across runs, it is
+// shown alongside the PC when both are on.
+func WithPCOffset(offset bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.PCOffset = offset
+	}
+}
+
+// WithFoldByFile prints each file path once as a header with the consecutive frames from that file listed
+// beneath it as "func:line" entries, reducing repetition of long paths while keeping call order. A frame's source
+// follows its entry on its own, WithChunkFormat doesn't apply.
+func WithFoldByFile(fold bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.FoldByFile = fold
+	}
+}
+
+// WithFromDefer makes a trace captured inside a deferred func start at the function that registered the defer,
+// hiding the deferred func and the runtime's defer machinery, SkipFrames then counts from there.
+// It assumes one defer layer: the capture must be called directly from the deferred func, e.g.
+//
+//	defer func() { log.Printf("%s", NewStackTrace(WithFromDefer(true))) }()
+//
+// While panicking the registering function is only found when the deferred func is a closure defined in it,
+// otherwise the trace starts at the panic site.
+func WithFromDefer(fromDefer bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.FromDefer = fromDefer
+	}
+}
+
+// WithMaxFuncNameLen truncates func names longer than n runes with a "…", zero disables truncation.
+func WithMaxFuncNameLen(n int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.MaxFuncNameLen = n
+	}
+}
+
+// WithTruncateFuncTail keeps the tail of truncated func names, e.g. "…Handler).ServeHTTP", instead of the head.
+func WithTruncateFuncTail(keepTail bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.TruncateFuncTail = keepTail
+	}
+}
+
+// WithOnFileLoad calls fn the first time each source file is loaded during a capture, ok reports whether it could
+// be read. Loads are cached so fn is called at most once per file per capture, e.g. to audit missing sources.
+// Calls are serialized, so fn needs no locking of its own even with WithParallelism.
+func WithOnFileLoad(fn func(path string, ok bool)) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.OnFileLoad = fn
+	}
+}
+
+// WithTreeIndent indents every frame one ChunkIndentation level deeper than the previous, producing a stair-step
+// view of the call chain that reads top-down when combined with WithReverseOrder. Indentation stops growing
+// after 16 levels.
+func WithTreeIndent(tree bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.TreeIndent = tree
+	}
+}
+
+// WithNormalizePaths renders windows paths with forward slashes and a lowercase drive letter, e.g. "c:/src/main.go",
+// for consistent cross-platform comparison. Non-windows paths are left unchanged.
+func WithNormalizePaths(normalize bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.NormalizePaths = normalize
+	}
+}
+
+// WithStableKey appends a "key=pkg/path.Symbol" token per frame, the per-frame analog of Fingerprint for building
+// per-function crash dashboards. Line numbers are deliberately excluded so keys survive unrelated edits.
+func WithStableKey(key bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.StableKey = key
+	}
+}
+
+// WithSourceMTime appends the modification time of each frame's source file, helping to notice source on disk
+// that was edited after the binary was built. It is omitted when the file can't be read or stat'ed.
+func WithSourceMTime(mtime bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SourceMTime = mtime
+	}
+}
+
+// WithCaret draws a "^" caret line under each frame's source line, like compiler error output. Go frames don't carry a
+// column so it points at the first non-whitespace character unless WithCaretColumn says otherwise.
+// Only meaningful when source is shown.
+func WithCaret(caret bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.Caret = caret
+	}
+}
+
+// WithCaretColumn resolves the 1-based column WithCaret points at per frame, returning 0 falls back to the first
+// non-whitespace character.
+func WithCaretColumn(fn func(f Frame) int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.CaretColumn = fn
+	}
+}
+
+// WithMaxFunctionLines bounds how many lines the function-progress annotation scans looking for the end of a function,
+// keeping it cheap on generated or malformed source. Beyond the cap the span collected so far is shown followed by
+// "...". Defaults to 200, values <= 0 use the default.
+func WithMaxFunctionLines(n int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.MaxFunctionLines = n
+	}
+}
+
+// WithGoroutineStateFilter limits NewAllGoroutinesTrace and AllGoroutinesJSON to goroutines whose state contains
+// any of states, e.g. "chan receive" or "semacquire". No states includes all goroutines.
+func WithGoroutineStateFilter(states ...string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.GoroutineStates = states
+	}
+}
+
+// WithStableClosureNames renders every closure of a function as "enclosing.<closure>" instead of func1, func2, ...
+// whose numbering shifts as closures are added or reordered, keeping output and keys comparable across edits.
+// Takes precedence over WithEnclosingContext.
+func WithStableClosureNames(stable bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.StableClosureNames = stable
+	}
+}
+
+// WithProfileLabels adds a "label.key=value" header line per pprof label set on ctx, tying the trace to
+// request-scoped labels. The runtime only exposes labels through a context, pass the one handed to pprof.Do or
+// pprof.WithLabels. Nothing is added when ctx carries no labels.
+func WithProfileLabels(ctx context.Context) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ProfileLabels = ctx
+	}
+}
+
+// WithWrapWidth soft-wraps frame and source lines longer than cols columns for fixed-width displays, breaking at
+// spaces where possible and indenting continuation lines. The width includes WithBoxDrawing connectors and
+// WithGlobalIndent. Escape sequences don't count towards the width and editor links are never broken, tabs
+// advance to the next multiple of 8. Zero disables wrapping.
+func WithWrapWidth(cols int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.WrapWidth = cols
+	}
+}
+
+// WithOriginHeader prints the package import path of the top frame as the first line, e.g. "origin: myapp/server",
+// for an at-a-glance location before the detailed frames. Omitted when no frames are captured.
+func WithOriginHeader(origin bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.OriginHeader = origin
+	}
+}
+
+// WithChunkFormat sets the layout of the func and source line using the placeholders {indent}, {func} and {code},
+// e.g. "{indent}{code}  // {func}". Defaults to "{indent}{func}: {code}".
+func WithChunkFormat(format string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ChunkFormat = format
+	}
+}
+
+// WithUnvendorPaths strips the ".../vendor/" prefix from file paths and func names so vendored frames read as their
+// upstream import path, rendering vendored and module cache builds alike.
+func WithUnvendorPaths(unvendor bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.UnvendorPaths = unvendor
+	}
+}
+
+// WithContextKeys sets the context keys whose values CaptureWithContext includes in the report, named by their
+// fmt.Sprint form. Defaults to the plain string keys "request_id" and "trace_id".
+func WithContextKeys(keys ...interface{}) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ContextKeys = keys
+	}
+}
+
+// WithOriginColors colors app, standard library and dependency frames differently so your own code stands out in a
+// deep trace. App frames are recognized through the binary's build info. Nothing is colored when NO_COLOR is set.
+func WithOriginColors(colors bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.OriginColors = colors
+	}
+}
+
+// WithColorScheme overrides the escape sequences used by WithOriginColors.
+func WithColorScheme(scheme ColorScheme) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ColorScheme = scheme
+	}
+}
+
+// WithStackSize annotates each goroutine of NewAllGoroutinesTrace with the size of its stack, helping to spot deep
+// recursion in a large dump. The runtime doesn't report stack sizes so the frame count stands in, suffixed with "+"
+// when the runtime elided frames.
+func WithStackSize(size bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.StackSize = size
+	}
+}
+
+// WithDedupeGoroutines groups the goroutines of NewAllGoroutinesTrace sharing the same stack, printing one
+// representative headed "(xN goroutines)". Goroutines are grouped by the Fingerprint of their frames.
+func WithDedupeGoroutines(dedupe bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.DedupeGoroutines = dedupe
+	}
+}
+
+// WithGlobalIndent prefixes every line of the output, the first included, with prefix, visually grouping a trace
+// embedded under a log message. Unlike ChunkIndentation it applies to all lines, not just the func and code lines.
+func WithGlobalIndent(prefix string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.GlobalIndent = prefix
+	}
+}
+
+// WithGitBlame appends the short commit hash and author of each frame's line as reported by git blame, for
+// development builds running next to their checkout. It shells out to git once per file:line and caches the result
+// for the life of the process, frames git can't blame are left as is. Only files the source reading options allow
+// to be read are blamed. Off by default.
+func WithGitBlame(blame bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.GitBlame = blame
+	}
+}
+
+// WithSourceContextBeforeAfter shows before lines of source before and after lines after each frame's line,
+// overriding the symmetric WithSourceContext. The window is clamped at the start and end of the file.
+func WithSourceContextBeforeAfter(before, after int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SourceContextBefore = before
+		cfg.SourceContextAfter = after
+	}
+}
+
+// WithQualifiedReceiver keeps the package on the receiver type of short method names, rendering "pkg.(*Type).Method"
+// instead of "(*Type).Method" to tell same-named methods of different packages apart. Only applies with
+// ShortFuncNames.
+func WithQualifiedReceiver(qualified bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.QualifiedReceiver = qualified
+	}
+}
+
+// WithBoxDrawing connects the frames into a visual call tree with box-drawing characters ("├─", "└─", "│"),
+// a polished alternative to plain separators for CLI tools.
+func WithBoxDrawing(box bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.BoxDrawing = box
+	}
+}
+
+// WithASCIIOnly makes WithBoxDrawing fall back to ASCII connectors ("|-", "`-", "|") for terminals lacking Unicode.
+func WithASCIIOnly(ascii bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ASCIIOnly = ascii
+	}
+}
+
+// WithErrorChain adds the messages of the errors wrapped by a reported error, found through errors.Unwrap, to
+// NewReport and as "caused by:" lines above the frames of TracedError's %+v, pairing the error narrative with
+// the stack. TracedError's formatting follows the defaults registered with SetDefaultOptions.
+func WithErrorChain(chain bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ErrorChain = chain
+	}
+}
+
+// WithRepoRelative renders file paths relative to the repository root, found by walking up from the top frame's
+// file to the closest directory holding a .git directory or a go.mod. The root is detected once per capture,
+// paths outside of it, or all paths when no root is found, are left as is.
+func WithRepoRelative(relative bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.RepoRelative = relative
+	}
+}
+
+// WithMaxGoroutines caps the goroutines rendered by NewAllGoroutinesTrace and WriteAllGoroutines at n, followed by a
+// "... (M more goroutines)" footer. The cap applies after WithGoroutineStateFilter and WithDedupeGoroutines.
+// Zero renders all goroutines.
+func WithMaxGoroutines(n int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.MaxGoroutines = n
+	}
+}
+
+// WithSyntaxHighlight colors keywords, strings, comments and numbers of the source shown, using a minimal
+// single-line Go lexer and the colors of the ColorScheme. Nothing is colored when NO_COLOR is set.
+func WithSyntaxHighlight(highlight bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SyntaxHighlight = highlight
+	}
+}
+
+// WithMaxSourceLineLen shortens source lines longer than n runes, noting how many were left out so a partial line
+// isn't mistaken for the whole, e.g. "if err := load(ctx, cfg… (+120 chars)". Zero shows lines in full.
+func WithMaxSourceLineLen(n int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.MaxSourceLineLen = n
+	}
+}
+
+// WithNDJSONSentinel sets the line NewStackTraceNDJSON returns for an empty stack, e.g. `{"frames":0}`,
+// for pipelines that expect at least one line per trace.
+func WithNDJSONSentinel(line string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.NDJSONSentinel = line
+	}
+}
+
+// WithInAppPackages marks frames of packages whose import path starts with any of prefixes as your own code,
+// appending " [app]" to them, or highlighting them instead when WithOriginColors is on. The prefixes also decide
+// which frames are app frames for WithOriginColors and in_app for SentryFrames, taking precedence over detecting
+// the main module from build info. Called without prefixes it marks the frames of the detected main module.
+func WithInAppPackages(prefixes ...string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.InAppPackages = prefixes
+		cfg.MarkInApp = true
+	}
+}
+
+// WithSkipFileLines drops frames on lines from through to of file, e.g. a generated block inside an otherwise
+// interesting file. Files are matched by path suffix so "gen/api.go" matches however the binary was built.
+// Each call adds a rule.
+func WithSkipFileLines(file string, from, to int) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SkipFileLines = append(cfg.SkipFileLines[:len(cfg.SkipFileLines):len(cfg.SkipFileLines)], FileLines{File: file, From: from, To: to})
+	}
+}
+
+// WithPCFormat sets how PCs are printed, "hex" (the default, e.g. 0x4a2b1c), "decimal" or a single fmt integer verb
+// such as "%#016x". Anything else falls back to hex.
+func WithPCFormat(format string) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.PCFormat = format
+	}
+}
+
+// WithThreadID adds a "thread=N" header line with the id of the OS thread the capture ran on, to correlate with
+// native thread dumps. Unless the goroutine called runtime.LockOSThread the scheduler may move it to another
+// thread at any time, so the id only reflects the moment of capture. Only available on Linux, elsewhere the line
+// is omitted.
+func WithThreadID(threadID bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ThreadID = threadID
+	}
+}
+
+// WithPackageContext also shows the package clause and imports of the top frame's file below its source, telling
+// readers which package and imports are in play. At most 10 imports are listed, nothing is added when source isn't
+// available. Off by default given its verbosity.
+func WithPackageContext(context bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.PackageContext = context
+	}
+}
+
+// WithNativeFormat renders frames exactly like the runtime does, "pkg.Func(...)" followed by "\tfile:line +0x1d",
+// headed by "goroutine N [running]:", so the output can be fed to tools parsing debug.Stack. Arguments aren't
+// available and are always shown as "(...)". Per-frame options other than path formatting don't apply.
+func WithNativeFormat(native bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.NativeFormat = native
+	}
+}
+
+// WithSourceNotes surfaces "//traceutils:note <text>" comments found on a frame's line or the line above it as
+// "note: <text>" lines below the frame, letting developers leave breadcrumbs for whoever reads the crash.
+// Best-effort, frames without source get no notes.
+func WithSourceNotes(notes bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.SourceNotes = notes
+	}
+}
+
+// WithIncludeSelf keeps the frames of this package's own functions, which are elided by default so the capture
+// helpers never show up in user traces, even when SkipFrames is miscounted. Meant for debugging the package itself.
+func WithIncludeSelf(include bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.IncludeSelf = include
+	}
+}
+
+// WithErrorAge adds an "age=..." line, the time since WithStack captured the error, below the message in
+// TracedError's %+v, telling fresh errors from ones that sat in a retry queue. TracedError's formatting follows the
+// defaults registered with SetDefaultOptions.
+func WithErrorAge(age bool) StackTraceOption {
+	return func(cfg *StackTraceConfig) {
+		cfg.ErrorAge = age
+	}
+}
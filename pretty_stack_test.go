@@ -0,0 +1,561 @@
+package traceUtils_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	traceUtils "github.com/karsto/common"
+)
+
+// panickingResolver panics for every PC it is asked about.
+type panickingResolver struct{}
+
+func (panickingResolver) Resolve(pc uintptr) (string, string, int, bool) {
+	panic("resolver broke")
+}
+
+func TestPanickingResolverKeepsTrace(t *testing.T) {
+	out := string(traceUtils.NewStackTrace(traceUtils.WithSymbolResolver(panickingResolver{})))
+	if !strings.Contains(out, "TestPanickingResolverKeepsTrace") {
+		t.Fatalf("expected the runtime's resolution of the caller, got:\n%s", out)
+	}
+	if !strings.Contains(out, "panic while resolving symbols: resolver broke") {
+		t.Fatalf("expected a note about the resolver's panic, got:\n%s", out)
+	}
+
+	err := traceUtils.Protect(func() error { panic("x") }, traceUtils.WithSymbolResolver(panickingResolver{}))
+	if err == nil || err.Error() != "panic: x" {
+		t.Fatalf("expected the original panic, got %v", err)
+	}
+	if frames := err.(*traceUtils.TracedError).Frames(); len(frames) == 0 {
+		t.Fatal("expected the frames of the panic site")
+	}
+}
+
+// self is the prefix of this package's full func names, which must not leak into default output.
+const self = "github.com/karsto/common."
+
+func TestNoSelfFrames(t *testing.T) {
+	out := string(traceUtils.NewStackTrace(traceUtils.WithShortFuncNames(false)))
+	if strings.Contains(out, self) {
+		t.Fatalf("package frame leaked into the trace:\n%s", out)
+	}
+	if !strings.Contains(out, "TestNoSelfFrames") {
+		t.Fatalf("expected the caller on top, got:\n%s", out)
+	}
+
+	out = string(traceUtils.NewStackTrace(traceUtils.WithShortFuncNames(false), traceUtils.WithIncludeSelf(true)))
+	if !strings.Contains(out, self+"NewStackTrace") {
+		t.Fatalf("expected the entry point with WithIncludeSelf, got:\n%s", out)
+	}
+}
+
+func TestEditorLinksRespectPathRedactor(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	out := string(traceUtils.NewStackTrace(
+		traceUtils.WithEditorLinks("vscode"),
+		traceUtils.WithPathRedactor(func(string) string { return "redacted.go" }),
+	))
+	if strings.Contains(out, file) || strings.Contains(out, "\x1b]8;;") {
+		t.Fatalf("expected no link carrying the real path, got:\n%q", out)
+	}
+}
+
+func TestInAppMarker(t *testing.T) {
+	noSource := traceUtils.WithIncludeSourceCode(false)
+	for name, opt := range map[string]traceUtils.StackTraceOption{
+		"prefixes":    traceUtils.WithInAppPackages("github.com/karsto/common"),
+		"auto-detect": traceUtils.WithInAppPackages(),
+	} {
+		out := string(traceUtils.NewStackTrace(noSource, opt))
+		if !strings.Contains(out, "[app]") {
+			t.Errorf("%s: expected the test frame marked as app, got:\n%s", name, out)
+		}
+	}
+	if out := string(traceUtils.NewStackTrace(noSource)); strings.Contains(out, "[app]") {
+		t.Errorf("expected no marker by default, got:\n%s", out)
+	}
+}
+
+// TestConcurrentCaptures is meant to be run with -race, concurrent captures must not share mutable state.
+func TestConcurrentCaptures(t *testing.T) {
+	captures := []func() []byte{
+		func() []byte { return traceUtils.NewStackTrace() },
+		func() []byte {
+			return traceUtils.NewStackTrace(traceUtils.WithShortFuncNames(false), traceUtils.WithMaxFrames(1))
+		},
+		func() []byte { return traceUtils.NewAllGoroutinesTrace(traceUtils.WithParallelism(4)) },
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(capture func() []byte) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if len(capture()) == 0 {
+					t.Error("empty trace")
+				}
+			}
+		}(captures[i%len(captures)])
+	}
+	wg.Wait()
+}
+
+// topFunc returns the func of the first frame of a trace rendered with WithIncludeSourceCode(false).
+func topFunc(trace []byte) string {
+	lines := strings.Split(string(trace), "\n")
+	if len(lines) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(lines[1])
+}
+
+func TestFromDefer(t *testing.T) {
+	opts := []traceUtils.StackTraceOption{traceUtils.WithFromDefer(true), traceUtils.WithIncludeSourceCode(false)}
+
+	var onReturn []byte
+	func() {
+		defer func() { onReturn = traceUtils.NewStackTrace(opts...) }()
+	}()
+	if top := topFunc(onReturn); top != "TestFromDefer.func1" {
+		t.Errorf("on return: expected the registering func on top, got:\n%s", onReturn)
+	}
+
+	var onPanic []byte
+	func() {
+		defer func() {
+			onPanic = traceUtils.NewStackTrace(opts...)
+			recover()
+		}()
+		boom()
+	}()
+	if top := topFunc(onPanic); top != "TestFromDefer.func2" {
+		t.Errorf("on panic: expected the registering func on top, got:\n%s", onPanic)
+	}
+}
+
+//go:noinline
+func boom() {
+	panic("boom")
+}
+
+var markInlined = []traceUtils.StackTraceOption{traceUtils.WithMarkInlined(true), traceUtils.WithIncludeSourceCode(false)}
+
+// captureInlined is small enough to be inlined into its caller unless optimizations are off.
+func captureInlined() []byte {
+	return traceUtils.NewStackTrace(markInlined...)
+}
+
+// tinyCallers is as small as captureInlined, telling from the runtime alone whether inlining is on.
+func tinyCallers(pcs []uintptr) int {
+	return runtime.Callers(1, pcs)
+}
+
+func TestMarkInlined(t *testing.T) {
+	pcs := make([]uintptr, 1)
+	tinyCallers(pcs)
+	if f, _ := runtime.CallersFrames(pcs).Next(); f.Func != nil {
+		t.Skip("inlining is disabled")
+	}
+	out := captureInlined()
+	lines := strings.Split(string(out), "\n")
+	if len(lines) < 2 || strings.TrimSpace(lines[1]) != "captureInlined" || !strings.HasSuffix(lines[0], " (inlined)") {
+		t.Fatalf("expected the inlined capture helper marked on top, got:\n%s", out)
+	}
+}
+
+func TestEnclosingContext(t *testing.T) {
+	opts := []traceUtils.StackTraceOption{traceUtils.WithEnclosingContext(true), traceUtils.WithIncludeSourceCode(false)}
+
+	var oneLevel, twoLevels []byte
+	func() {
+		oneLevel = traceUtils.NewStackTrace(opts...)
+	}()
+	func() {
+		func() {
+			twoLevels = traceUtils.NewStackTrace(opts...)
+		}()
+	}()
+	if top := topFunc(oneLevel); top != "TestEnclosingContext[func1]" {
+		t.Errorf("one level: got %q", top)
+	}
+	// nested closures are named func2.1 or, by newer compilers, func2.func1
+	if top := topFunc(twoLevels); !strings.HasPrefix(top, "TestEnclosingContext[func2.") || !strings.HasSuffix(top, "1]") {
+		t.Errorf("two levels: got %q", top)
+	}
+}
+
+type recvValue struct{ out *[]byte }
+
+func (r recvValue) capture() {
+	*r.out = traceUtils.NewStackTrace(traceUtils.WithQualifiedReceiver(true), traceUtils.WithIncludeSourceCode(false))
+}
+
+type recvPointer struct{ out []byte }
+
+func (r *recvPointer) capture() {
+	recvValue{out: &r.out}.capture()
+}
+
+func TestQualifiedReceiver(t *testing.T) {
+	r := &recvPointer{}
+	// value and pointer receivers of this package, net/http and sync
+	http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		var once sync.Once
+		once.Do(r.capture)
+	}).ServeHTTP(nil, nil)
+
+	out := string(r.out)
+	for _, name := range []string{
+		"common_test.recvValue.capture",
+		"common_test.(*recvPointer).capture",
+		"sync.(*Once).doSlow",
+		"http.HandlerFunc.ServeHTTP",
+	} {
+		if !strings.Contains(out, "\t"+name+"\n") {
+			t.Errorf("expected %s in:\n%s", name, out)
+		}
+	}
+}
+
+func TestFoldByFile(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	var folded, noLines []byte
+	func() {
+		opts := []traceUtils.StackTraceOption{traceUtils.WithFoldByFile(true), traceUtils.WithIncludePC(false), traceUtils.WithMaxFrames(2)}
+		folded = traceUtils.NewStackTrace(append(opts, traceUtils.WithIncludeSourceCode(false))...)
+		noLines = traceUtils.NewStackTrace(append(opts, traceUtils.WithIncludeSourceCode(false), traceUtils.WithShowLineNumbers(false))...)
+	}()
+
+	lines := strings.Split(string(folded), "\n")
+	if len(lines) != 3 || lines[0] != file ||
+		!strings.HasPrefix(lines[1], "\tTestFoldByFile.func1:") || !strings.HasPrefix(lines[2], "\tTestFoldByFile:") {
+		t.Errorf("expected one file header with a func:line entry per frame, got:\n%s", folded)
+	}
+	if want := file + "\n\tTestFoldByFile.func1\n\tTestFoldByFile"; string(noLines) != want {
+		t.Errorf("expected bare func entries without line numbers, got:\n%s", noLines)
+	}
+}
+
+func TestDepthRangeCountsBeforeSkipFileLines(t *testing.T) {
+	var out []byte
+	func() {
+		// the window holds this closure and the test func, skipping both leaves nothing to render
+		out = traceUtils.NewStackTrace(
+			traceUtils.WithDepthRange(0, 1),
+			traceUtils.WithSkipFileLines("pretty_stack_test.go", 1, 1<<30),
+		)
+	}()
+	if len(out) != 0 {
+		t.Fatalf("expected the depth range to be taken before skipping file lines, got:\n%s", out)
+	}
+}
+
+// runtimeLocation returns the "\tfile:line +0x.." line following fn's frame in a runtime formatted stack.
+func runtimeLocation(stack, fn string) string {
+	lines := strings.Split(stack, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, fn+"(") && i+1 < len(lines) {
+			return lines[i+1]
+		}
+	}
+	return ""
+}
+
+func TestNativeFormatMatchesRuntime(t *testing.T) {
+	var native, runtimeStack string
+	func() {
+		native = string(traceUtils.NewStackTrace(traceUtils.WithNativeFormat(true)))
+		runtimeStack = string(debug.Stack())
+	}()
+
+	// both captures share the frames below the test func, the closure may be inlined into it
+	want := runtimeLocation(runtimeStack, "testing.tRunner")
+	if want == "" {
+		t.Fatalf("tRunner missing from the runtime's stack:\n%s", runtimeStack)
+	}
+	if got := runtimeLocation(native, "testing.tRunner"); got != want {
+		t.Errorf("got %q, the runtime prints %q", got, want)
+	}
+}
+
+func TestWrapWidthIncludesPrefixes(t *testing.T) {
+	const width = 30
+	out := traceUtils.NewStackTrace(
+		traceUtils.WithWrapWidth(width),
+		traceUtils.WithBoxDrawing(true),
+		traceUtils.WithGlobalIndent("> "),
+	)
+	for _, line := range strings.Split(string(out), "\n") {
+		if n := displayColumns(stripEscapes(line)); n > width {
+			t.Errorf("line of %d columns exceeds %d: %q", n, width, line)
+		}
+	}
+}
+
+func TestWrapWidthKeepsHyperlinks(t *testing.T) {
+	out := traceUtils.NewStackTrace(traceUtils.WithWrapWidth(30), traceUtils.WithEditorLinks("vscode"))
+	for _, line := range strings.Split(string(out), "\n") {
+		if opens, closes := strings.Count(line, "\x1b]8;;vscode:"), strings.Count(line, "\x1b]8;;\x1b\\"); opens != closes {
+			t.Errorf("hyperlink split across lines: %q", line)
+		}
+	}
+}
+
+// stripEscapes removes the ANSI CSI and OSC escape sequences from s.
+func stripEscapes(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\x1b' || i+1 >= len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '[':
+			for i += 2; i < len(s) && (s[i] < 0x40 || s[i] > 0x7e); i++ {
+			}
+		case ']':
+			for i += 2; i < len(s) && !(s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '\\'); i++ {
+			}
+			i++
+		}
+	}
+	return out.String()
+}
+
+// displayColumns returns the width of s on a terminal with tab stops every 8 columns.
+func displayColumns(s string) int {
+	col := 0
+	for _, r := range s {
+		if r == '\t' {
+			col = (col/8 + 1) * 8
+		} else {
+			col++
+		}
+	}
+	return col
+}
+
+// vendoredResolver resolves every PC to a func of a vendored package.
+type vendoredResolver struct{}
+
+func (vendoredResolver) Resolve(pc uintptr) (string, string, int, bool) {
+	return "myapp/vendor/github.com/foo/bar.Baz", "/src/myapp/vendor/github.com/foo/bar/baz.go", 7, true
+}
+
+func TestUnvendorPathsAllFormats(t *testing.T) {
+	opts := []traceUtils.StackTraceOption{
+		traceUtils.WithSymbolResolver(vendoredResolver{}),
+		traceUtils.WithUnvendorPaths(true),
+		traceUtils.WithShortFuncNames(false),
+		traceUtils.WithIncludeSourceCode(false),
+	}
+	yaml, err := traceUtils.NewStackTraceYAML(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := json.Marshal(traceUtils.NewReport(nil, opts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sentry, err := json.Marshal(traceUtils.SentryFrames(opts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := map[string]string{
+		"text":      string(traceUtils.NewStackTrace(opts...)),
+		"logfmt":    string(traceUtils.NewStackTraceLogfmt(opts...)),
+		"ndjson":    string(traceUtils.NewStackTraceNDJSON(opts...)),
+		"yaml":      string(yaml),
+		"syslog":    string(traceUtils.NewStackTraceSyslogSD(opts...)),
+		"collapsed": traceUtils.CollapsedLine(opts...),
+		"report":    string(report),
+		"sentry":    string(sentry),
+	}
+	for name, out := range outputs {
+		if strings.Contains(out, "vendor/") || !strings.Contains(out, "github.com/foo/bar") {
+			t.Errorf("%s: expected unvendored names, got:\n%s", name, out)
+		}
+	}
+}
+
+// fileResolver resolves every PC to line 1 of file.
+type fileResolver string
+
+func (r fileResolver) Resolve(pc uintptr) (string, string, int, bool) {
+	return "main.main", string(r), 1, true
+}
+
+func TestGitBlameRespectsSourceRoots(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	file := filepath.Join(repo, "main.go")
+	if err := os.WriteFile(file, []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "main.go"},
+		{"-c", "user.name=blame", "-c", "user.email=blame@example.com", "commit", "-qm", "init"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Skipf("git %v: %v %s", args, err, out)
+		}
+	}
+
+	opts := []traceUtils.StackTraceOption{traceUtils.WithSymbolResolver(fileResolver(file)), traceUtils.WithGitBlame(true)}
+	if out := string(traceUtils.NewStackTrace(opts...)); !strings.Contains(out, "blame=") {
+		t.Fatalf("expected a blame, got:\n%s", out)
+	}
+	out := string(traceUtils.NewStackTrace(append(opts, traceUtils.WithSourceRoots(t.TempDir()))...))
+	if strings.Contains(out, "blame=") {
+		t.Errorf("expected no blame outside the source roots, got:\n%s", out)
+	}
+}
+
+func TestStripCommentsKeepsLiterals(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{`x := 1 // trailing`, `x := 1`},
+		{`url := "http://example.com" // site`, `url := "http://example.com"`},
+		{`s := "a \" // b" // c`, `s := "a \" // b"`},
+		{"raw := `//` // d", "raw := `//`"},
+		{`r := '/' + '/' // e`, `r := '/' + '/'`},
+		{`q := '\'' // f`, `q := '\''`},
+	}
+	dir := t.TempDir()
+	for i, tt := range tests {
+		file := filepath.Join(dir, strconv.Itoa(i)+".go")
+		if err := os.WriteFile(file, []byte(tt.line+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		out := string(traceUtils.NewStackTrace(
+			traceUtils.WithSymbolResolver(fileResolver(file)),
+			traceUtils.WithStripComments(true),
+		))
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%s: expected %q, got:\n%s", tt.line, tt.want, out)
+		}
+		if comment := tt.line[strings.LastIndex(tt.line, "//"):]; strings.Contains(out, comment) {
+			t.Errorf("%s: expected %q stripped, got:\n%s", tt.line, comment, out)
+		}
+	}
+}
+
+// funcResolver resolves every PC to a func with the given name.
+type funcResolver string
+
+func (r funcResolver) Resolve(pc uintptr) (string, string, int, bool) {
+	return string(r), "/src/main.go", 7, true
+}
+
+func TestSyslogSDEscapesParamValues(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{`main.plain`, `frame0="plain /src/main.go:7"`},
+		{`main.say"hi"`, `frame0="say\"hi\" /src/main.go:7"`},
+		{`main.back\slash`, `frame0="back\\slash /src/main.go:7"`},
+		{`main.index[T]`, `frame0="index[T\] /src/main.go:7"`},
+		{`main.all\"]`, `frame0="all\\\"\] /src/main.go:7"`},
+	}
+	for _, tt := range tests {
+		out := string(traceUtils.NewStackTraceSyslogSD(traceUtils.WithSymbolResolver(funcResolver(tt.name))))
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, out)
+		}
+	}
+}
+
+func TestPCFormatRejectsInvalid(t *testing.T) {
+	hex := regexp.MustCompile(`\(0x[0-9a-f]+\)`)
+	tests := []struct {
+		format string
+		want   *regexp.Regexp
+	}{
+		{"", hex},
+		{"hex", hex},
+		{"decimal", regexp.MustCompile(`\([0-9]+\)`)},
+		{"%d", regexp.MustCompile(`\([0-9]+\)`)},
+		{"%#x", hex},
+		{"%016X", regexp.MustCompile(`\([0-9A-F]{16}\)`)},
+		{"%o", regexp.MustCompile(`\([0-7]+\)`)},
+		// anything but a single integer verb falls back to hex
+		{"%", hex},
+		{"%s", hex},
+		{"%v", hex},
+		{"%d%d", hex},
+		{"pc=%x", hex},
+		{"%x!", hex},
+		{"%*d", hex},
+		{"%.3d", hex},
+		{"x", hex},
+	}
+	for _, tt := range tests {
+		out := string(traceUtils.NewStackTrace(traceUtils.WithIncludePC(true), traceUtils.WithPCFormat(tt.format)))
+		if !tt.want.MatchString(out) {
+			t.Errorf("%q: expected %s, got:\n%s", tt.format, tt.want, out)
+		}
+		if strings.Contains(out, "%!") {
+			t.Errorf("%q: fmt error in output:\n%s", tt.format, out)
+		}
+	}
+}
+
+func TestDecompressTrace(t *testing.T) {
+	opts := []traceUtils.StackTraceOption{traceUtils.WithSymbolResolver(funcResolver("main.main")), traceUtils.WithIncludePC(false)}
+	encoded, err := traceUtils.NewStackTraceCompressed(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := traceUtils.DecompressTrace(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := traceUtils.NewStackTrace(opts...); !bytes.Equal(decoded, want) {
+		t.Errorf("round-trip mismatch, got:\n%s\nwant:\n%s", decoded, want)
+	}
+
+	// gzip+base64 produced elsewhere decodes too
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("main.main()\n"))
+	zw.Close()
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: base64.StdEncoding.EncodeToString(buf.Bytes()), want: "main.main()\n"},
+		{in: "not base64!", wantErr: true},
+		{in: base64.StdEncoding.EncodeToString([]byte("not gzip")), wantErr: true},
+		{in: base64.RawStdEncoding.EncodeToString(buf.Bytes()), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := traceUtils.DecompressTrace(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error %v", tt.in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package traceUtils
+
+import "fmt"
+
+// RecoverWithValue - returns the recovered panic value, the frames at the panic site and whether a panic occurred.
+// recover only stops a panic when called directly by the deferred func, so it has to be passed in:
+//
+//	defer func() {
+//		if value, frames, ok := RecoverWithValue(recover()); ok {
+//			...
+//		}
+//	}()
+//
+// The frames start at the panic origin, not at the recovery machinery. ok is false on a normal return.
+func RecoverWithValue(recovered interface{}) (value interface{}, frames []Frame, ok bool) {
+	if recovered == nil {
+		return nil, nil, false
+	}
+	return recovered, newTrace(1, []StackTraceOption{fromPanic()}).frames, true
+}
+
+// Protect - runs fn and returns a panic inside it as a TracedError instead of crashing, for workers, CLI commands
+// and anything else outside of an HTTP server. The frames start at the panic site inside fn and are captured
+// according to opts. A panic value that is an error stays reachable through errors.Is and errors.As.
+// When fn doesn't panic its own error is returned unchanged.
+func Protect(fn func() error, opts ...StackTraceOption) (err error) {
+	opts = append(opts[:len(opts):len(opts)], fromPanic())
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		var cause error
+		if recoveredErr, ok := recovered.(error); ok {
+			cause = fmt.Errorf("panic: %w", recoveredErr)
+		} else {
+			cause = fmt.Errorf("panic: %v", recovered)
+		}
+		err = newTracedError(cause, newTrace(1, opts).frames)
+	}()
+	return fn()
+}
+
+// FormatPanic - returns a line describing the recovered panic value followed by the stack from the panic site,
+// the output most recover handlers want to log. Like RecoverWithValue it has to be called from the deferred func:
+//
+//	defer func() {
+//		if r := recover(); r != nil {
+//			log.Printf("%s", FormatPanic(r))
+//		}
+//	}()
+func FormatPanic(recovered interface{}, opts ...StackTraceOption) []byte {
+	var header string
+	switch v := recovered.(type) {
+	case nil:
+		header = "panic: nil (no panic value)"
+	case error:
+		header = fmt.Sprintf("panic: %v [error %T]", v, v)
+	case string:
+		header = "panic: " + v
+	default:
+		header = fmt.Sprintf("panic: %v [%T]", v, v)
+	}
+
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	stack := newStackTrace(1, append(opts[:len(opts):len(opts)], fromPanic()))
+	// the stack comes indented already, the header needs the same indent
+	out := append(indentLines([]byte(header), cfg.GlobalIndent), cfg.FrameSeparator...)
+	return append(out, stack...)
+}
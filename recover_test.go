@@ -0,0 +1,28 @@
+package traceUtils_test
+
+import (
+	"strings"
+	"testing"
+
+	traceUtils "github.com/karsto/common"
+)
+
+func TestFormatPanicGlobalIndent(t *testing.T) {
+	var out []byte
+	func() {
+		defer func() {
+			out = traceUtils.FormatPanic(recover(), traceUtils.WithGlobalIndent("  | "))
+		}()
+		panic("p")
+	}()
+
+	lines := strings.Split(string(out), "\n")
+	if lines[0] != "  | panic: p" {
+		t.Errorf("expected an indented header, got %q", lines[0])
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "  | ") || strings.HasPrefix(line, "  |   | ") {
+			t.Errorf("expected every line indented once, got %q", line)
+		}
+	}
+}
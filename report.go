@@ -0,0 +1,151 @@
+package traceUtils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// Report - a crash report combining an error message with the stack it came from, ready to be marshaled to JSON.
+type Report struct {
+	Message     string  `json:"message"`
+	Fingerprint string  `json:"fingerprint"`
+	Frames      []Frame `json:"frames"`
+	GoVersion   string  `json:"goVersion"`
+	// Context holds the request-scoped values picked from a context by CaptureWithContext.
+	Context map[string]string `json:"context,omitempty"`
+	// Chain holds the messages of the errors wrapped by the reported error, see WithErrorChain.
+	Chain []string `json:"chain,omitempty"`
+	// Meta holds arbitrary metadata attached with With, such as a user ID or the environment name.
+	Meta map[string]interface{} `json:"meta,omitempty"`
+}
+
+// With - returns a copy of r with key set to value in its Meta, r itself is left untouched.
+//
+//	report := NewReport(err).With("user", userID).With("env", "prod")
+func (r Report) With(key string, value interface{}) Report {
+	meta := make(map[string]interface{}, len(r.Meta)+1)
+	for k, v := range r.Meta {
+		meta[k] = v
+	}
+	meta[key] = value
+	r.Meta = meta
+	return r
+}
+
+// NewReport - returns a report for err. If err is or wraps a TracedError its frames are reused,
+// otherwise the current stack is captured according to opts. File paths follow the path options in opts such as
+// WithPathRedactor. With WithStableClosureNames the fingerprint ignores closure numbering.
+func NewReport(err error, opts ...StackTraceOption) Report {
+	var frames []Frame
+	var cfg StackTraceConfig
+	var traced *TracedError
+	if errors.As(err, &traced) {
+		frames = traced.Frames()
+		cfg = defaultConfig()
+		for _, opt := range opts {
+			opt(&cfg)
+		}
+		if cfg.RepoRelative && len(frames) > 0 {
+			cfg.repoRoot = repoRoot(frames[0].File)
+		}
+	} else {
+		t := newTrace(1, opts)
+		frames, cfg = t.frames, t.cfg
+	}
+
+	r := Report{
+		Fingerprint: reportFingerprint(frames, cfg),
+		Frames:      displayFrames(frames, cfg),
+		GoVersion:   runtime.Version(),
+	}
+	if err != nil {
+		r.Message = err.Error()
+		if cfg.ErrorChain {
+			r.Chain = errorChain(err)
+		}
+	}
+	return r
+}
+
+// CaptureWithContext - returns a report of the current stack captured according to opts, tied to the request that
+// produced it by the values ctx holds for the keys set with WithContextKeys. Keys without a value are omitted.
+func CaptureWithContext(ctx context.Context, opts ...StackTraceOption) Report {
+	t := newTrace(1, opts)
+	r := Report{
+		Fingerprint: reportFingerprint(t.frames, t.cfg),
+		Frames:      displayFrames(t.frames, t.cfg),
+		GoVersion:   runtime.Version(),
+	}
+	for _, key := range t.cfg.ContextKeys {
+		if value := ctx.Value(key); value != nil {
+			if r.Context == nil {
+				r.Context = make(map[string]string)
+			}
+			r.Context[fmt.Sprint(key)] = fmt.Sprint(value)
+		}
+	}
+	return r
+}
+
+// displayFrames returns a copy of frames with their files and full func names as rendered according to cfg, so
+// options such as WithPathRedactor and WithUnvendorPaths also apply to structured output.
+func displayFrames(frames []Frame, cfg StackTraceConfig) []Frame {
+	display := make([]Frame, len(frames))
+	for i, f := range frames {
+		f.File = displayPath(cfg, f.File)
+		f.Func = displayFunc(cfg, f.Func)
+		display[i] = f
+	}
+	return display
+}
+
+// reportFingerprint returns the Fingerprint of frames, ignoring closure numbering with cfg.StableClosureNames.
+func reportFingerprint(frames []Frame, cfg StackTraceConfig) string {
+	if !cfg.StableClosureNames {
+		return Fingerprint(frames)
+	}
+	stable := make([]Frame, len(frames))
+	for i, f := range frames {
+		f.Func = string(stableClosureName([]byte(f.Func)))
+		stable[i] = f
+	}
+	return Fingerprint(stable)
+}
+
+// Fingerprint - returns a stable hash of the functions in frames for grouping crashes. Files and line numbers are
+// deliberately left out so the fingerprint survives unrelated edits.
+func Fingerprint(frames []Frame) string {
+	h := sha256.New()
+	for _, f := range frames {
+		h.Write([]byte(f.Func))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// PackagesTouched - returns the distinct package import paths of the current stack captured according to opts,
+// in first-seen order from the innermost frame outward. It makes a compact, low-cardinality crash signature.
+func PackagesTouched(opts ...StackTraceOption) []string {
+	t := newTrace(1, opts)
+	frames := t.frames
+	if t.cfg.ReverseOrder {
+		frames = make([]Frame, len(t.frames))
+		for i, f := range t.frames {
+			frames[len(frames)-1-i] = f
+		}
+	}
+
+	seen := make(map[string]bool)
+	var packages []string
+	for _, f := range frames {
+		if pkg := funcPackage(f.Func); pkg != "" && !seen[pkg] {
+			seen[pkg] = true
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
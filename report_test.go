@@ -0,0 +1,51 @@
+package traceUtils_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	traceUtils "github.com/karsto/common"
+)
+
+func TestReportRedactsPaths(t *testing.T) {
+	redact := traceUtils.WithPathRedactor(func(string) string { return "redacted.go" })
+	reports := map[string]traceUtils.Report{
+		"captured": traceUtils.NewReport(errors.New("boom"), redact),
+		"traced":   traceUtils.NewReport(traceUtils.WithStack(errors.New("boom")), redact),
+	}
+	for name, r := range reports {
+		if len(r.Frames) == 0 {
+			t.Fatalf("%s: expected frames", name)
+		}
+		for _, f := range r.Frames {
+			if f.File != "redacted.go" {
+				t.Errorf("%s: unredacted path %s", name, f.File)
+			}
+		}
+	}
+}
+
+// TestStableClosureNames captures from two sibling closures which, like a closure before and after an edit
+// reorders it, only differ in their numbering.
+func TestStableClosureNames(t *testing.T) {
+	capture := func(opts ...traceUtils.StackTraceOption) (fingerprint, name string) {
+		fingerprint = traceUtils.NewReport(nil, append(opts, traceUtils.WithSkipFrames(1))...).Fingerprint
+		trace := traceUtils.NewStackTrace(append(opts, traceUtils.WithSkipFrames(1), traceUtils.WithIncludeSourceCode(false))...)
+		return fingerprint, strings.TrimSpace(strings.Split(string(trace), "\n")[1])
+	}
+
+	for _, stable := range []bool{false, true} {
+		opt := traceUtils.WithStableClosureNames(stable)
+		var first, second [2]string
+		func() { first[0], first[1] = capture(opt) }()
+		func() { second[0], second[1] = capture(opt) }()
+
+		if same := first == second; same != stable {
+			t.Errorf("stable %v: got %q and %q", stable, first, second)
+		}
+		if stable && first[1] != "TestStableClosureNames.<closure>" {
+			t.Errorf("unexpected stable name %q", first[1])
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package traceUtils
+
+import "sync/atomic"
+
+// Sampler - decides whether a trace should be captured at all, letting very hot error paths skip the cost of
+// walking the stack. It controls capture frequency only, formatting is up to the options passed to the capture.
+//
+//	if sampler.ShouldCapture() {
+//		log.Printf("%v\n%s", err, NewStackTrace())
+//	}
+type Sampler interface {
+	ShouldCapture() bool
+}
+
+// NewRateSampler - returns a Sampler capturing 1 in n calls, starting with the first. n <= 1 captures every call.
+// It is safe for concurrent use.
+func NewRateSampler(n int) Sampler {
+	if n < 1 {
+		n = 1
+	}
+	return &rateSampler{n: uint64(n)}
+}
+
+type rateSampler struct {
+	n     uint64
+	calls uint64 // accessed atomically
+}
+
+func (s *rateSampler) ShouldCapture() bool {
+	return (atomic.AddUint64(&s.calls, 1)-1)%s.n == 0
+}
@@ -0,0 +1,27 @@
+package traceUtils
+
+// SentryFrames - returns the stack as frame maps with the keys of Sentry's stack trace interface, function,
+// filename, lineno, context_line and in_app, oldest call first as Sentry expects. Frames of the packages given
+// to WithInAppPackages or, without those, of the main module according to the binary's build info are in_app.
+// No Sentry SDK is required, the maps can be fed into its StackTrace struct or sent as JSON.
+func SentryFrames(opts ...StackTraceOption) []map[string]interface{} {
+	t := newTrace(1, opts)
+	cfg := t.cfg
+	sources := newSourceCache(cfg)
+
+	frames := make([]map[string]interface{}, 0, len(t.frames))
+	for i := len(t.frames) - 1; i >= 0; i-- {
+		f := t.frames[i]
+		frame := map[string]interface{}{
+			"function": string(resolveFuncName(displayFunc(cfg, f.Func), cfg.ShortFuncNames)),
+			"filename": displayPath(cfg, f.File),
+			"lineno":   f.Line,
+			"in_app":   classifyOrigin(f.Func, cfg.InAppPackages) == originApp,
+		}
+		if cfg.IncludeSourceCode && f.Line >= 1 && f.Line <= len(sources.lines(f.File)) {
+			frame["context_line"] = string(sources.line(f.File, f.Line))
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
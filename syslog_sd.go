@@ -0,0 +1,29 @@
+package traceUtils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NewStackTraceSyslogSD - returns the stack trace as an RFC 5424 structured-data element for syslog pipelines,
+// `[stacktrace frame0="func file:line" frame1="..."]`. Use WithMaxFrames to keep the element within the size
+// limits of the transport. An empty stack gives `[stacktrace]`.
+func NewStackTraceSyslogSD(opts ...StackTraceOption) []byte {
+	t := newTrace(1, opts)
+	cfg := t.cfg
+
+	var out strings.Builder
+	out.WriteString("[stacktrace")
+	for i, f := range t.frames {
+		value := string(resolveFuncName(displayFunc(cfg, f.Func), cfg.ShortFuncNames)) + " " + displayPath(cfg, f.File)
+		if cfg.ShowLineNumbers {
+			value += ":" + strconv.Itoa(f.Line)
+		}
+		out.WriteString(" frame" + strconv.Itoa(i) + `="` + sdEscaper.Replace(value) + `"`)
+	}
+	out.WriteByte(']')
+	return []byte(out.String())
+}
+
+// sdEscaper escapes the characters RFC 5424 requires to be escaped in a PARAM-VALUE.
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
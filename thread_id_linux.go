@@ -0,0 +1,8 @@
+package traceUtils
+
+import "syscall"
+
+// osThreadID returns the id of the OS thread running the calling goroutine.
+func osThreadID() (int, bool) {
+	return syscall.Gettid(), true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package traceUtils
+
+// osThreadID isn't available on this platform.
+func osThreadID() (int, bool) {
+	return 0, false
+}
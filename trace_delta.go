@@ -0,0 +1,31 @@
+package traceUtils
+
+// TraceDelta - captures the current stack and renders only the frames above the part it shares with prev,
+// giving compact "what moved" output when sampling a stack repeatedly. Pass the returned frames as prev to the
+// next call, nil renders the whole stack. The rendering is empty when nothing changed.
+//
+//	var prev []Frame
+//	for item := range work {
+//		var moved []byte
+//		prev, moved = TraceDelta(prev)
+//		log.Printf("%s", moved)
+//		...
+//	}
+func TraceDelta(prev []Frame, opts ...StackTraceOption) (cur []Frame, deltaRendered []byte) {
+	t := newTrace(1, opts)
+	cur = t.frames
+
+	// frames are innermost first, so the shared part is a common suffix
+	common := 0
+	for common < len(cur) && common < len(prev) {
+		a, b := cur[len(cur)-1-common], prev[len(prev)-1-common]
+		if a.Func != b.Func || a.File != b.File || a.Line != b.Line {
+			break
+		}
+		common++
+	}
+	if common == len(cur) {
+		return cur, nil
+	}
+	return cur, renderFrames(cur[:len(cur)-common], t.cfg, newSourceCache(t.cfg))
+}
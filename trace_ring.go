@@ -0,0 +1,41 @@
+package traceUtils
+
+import "sync"
+
+// TraceRing - an in-memory history of the last captured traces for post-mortem retrieval, e.g. dumped on shutdown
+// or SIGQUIT. It is safe for concurrent use.
+type TraceRing struct {
+	mu     sync.Mutex
+	traces [][]byte
+	next   int  // slot the next capture goes into
+	full   bool // every slot has been written at least once
+}
+
+// NewTraceRing - returns a ring holding the last n traces, n < 1 holds one.
+func NewTraceRing(n int) *TraceRing {
+	if n < 1 {
+		n = 1
+	}
+	return &TraceRing{traces: make([][]byte, n)}
+}
+
+// Capture - captures the stack like NewStackTrace and stores it, evicting the oldest trace once the ring is full.
+func (r *TraceRing) Capture(opts ...StackTraceOption) {
+	trace := newStackTrace(1, opts)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.traces[r.next] = trace
+	r.next = (r.next + 1) % len(r.traces)
+	r.full = r.full || r.next == 0
+}
+
+// Dump - returns the stored traces, oldest first.
+func (r *TraceRing) Dump() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([][]byte(nil), r.traces[:r.next]...)
+	}
+	return append(append([][]byte(nil), r.traces[r.next:]...), r.traces[:r.next]...)
+}
@@ -0,0 +1,89 @@
+package traceUtils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TracedError - an error annotated with the stack at the point it was wrapped by WithStack.
+type TracedError struct {
+	err     error
+	frames  []Frame
+	created time.Time
+}
+
+// WithStack - annotates err with the caller's stack, returns nil when err is nil.
+func WithStack(err error) error {
+	if err == nil {
+		return nil
+	}
+	return newTracedError(err, captureFrames(1))
+}
+
+// newTracedError is the single constructor of TracedError, recording the capture time for Age.
+func newTracedError(err error, frames []Frame) *TracedError {
+	return &TracedError{err: err, frames: frames, created: time.Now()}
+}
+
+func (e *TracedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *TracedError) Unwrap() error {
+	return e.err
+}
+
+// Frames returns the stack captured by WithStack, innermost first.
+func (e *TracedError) Frames() []Frame {
+	return e.frames
+}
+
+// Age returns the time elapsed since WithStack captured the error.
+func (e *TracedError) Age() time.Duration {
+	return time.Since(e.created)
+}
+
+// Format implements fmt.Formatter, %+v includes the stack rendered with the default config, preceded by the
+// error's age and the messages of the wrapped error chain when the defaults include WithErrorAge and WithErrorChain.
+func (e *TracedError) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			cfg := defaultConfig()
+			io.WriteString(s, e.Error())
+			io.WriteString(s, cfg.FrameSeparator)
+			if cfg.ErrorAge {
+				io.WriteString(s, "age="+e.Age().Round(time.Millisecond).String()+cfg.FrameSeparator)
+			}
+			if cfg.ErrorChain {
+				for _, msg := range errorChain(e) {
+					io.WriteString(s, "caused by: "+msg+cfg.FrameSeparator)
+				}
+			}
+			s.Write(renderFrames(e.frames, cfg, newSourceCache(cfg)))
+			return
+		}
+		io.WriteString(s, e.Error())
+	case 's':
+		io.WriteString(s, e.Error())
+	case 'q':
+		fmt.Fprintf(s, "%q", e.Error())
+	}
+}
+
+// errorChain returns the messages of the errors err wraps, following errors.Unwrap until nil. Wrappers repeating
+// the message of the error before them, such as TracedError, are left out.
+func errorChain(err error) []string {
+	var chain []string
+	last := err.Error()
+	for inner := errors.Unwrap(err); inner != nil; inner = errors.Unwrap(inner) {
+		if msg := inner.Error(); msg != last {
+			chain = append(chain, msg)
+			last = msg
+		}
+	}
+	return chain
+}
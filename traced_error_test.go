@@ -0,0 +1,19 @@
+package traceUtils_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	traceUtils "github.com/karsto/common"
+)
+
+func TestAge(t *testing.T) {
+	wrapped := traceUtils.WithStack(errors.New("boom")).(*traceUtils.TracedError)
+	protected := traceUtils.Protect(func() error { panic("boom") }).(*traceUtils.TracedError)
+	for name, err := range map[string]*traceUtils.TracedError{"WithStack": wrapped, "Protect": protected} {
+		if age := err.Age(); age < 0 || age > time.Minute {
+			t.Errorf("%s: unexpected age %s", name, age)
+		}
+	}
+}
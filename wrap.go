@@ -0,0 +1,139 @@
+package traceUtils
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// tabWidth is the number of columns a tab is assumed to advance to when measuring lines for wrapping.
+const tabWidth = 8
+
+// wrapLine soft-wraps line at width columns, preferring to break at a space, for a line printed from column start
+// on, e.g. behind a prefix added later. Continuation lines repeat the line's own leading whitespace followed by
+// indent. Escape sequences take up no columns and the text of a hyperlink, such as those of WithEditorLinks, is
+// never broken.
+func wrapLine(line string, start, width int, indent string) []string {
+	indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))] + indent
+
+	var out []string
+	prefix := ""
+	for {
+		cut := wrapPoint(line, columns(prefix, start), width)
+		if cut == len(line) {
+			return append(out, prefix+line)
+		}
+		out = append(out, prefix+strings.TrimRight(line[:cut], " "))
+		line = strings.TrimLeft(line[cut:], " ")
+		if line == "" {
+			return out
+		}
+		prefix = indent
+	}
+}
+
+// wrapPoint returns the byte offset to break line at so that it fits in width columns when starting at column col,
+// len(line) when it fits entirely. At least one rune is always consumed. A hyperlink too long to fit is broken
+// before, or if it starts the line, after.
+func wrapPoint(line string, col, width int) int {
+	lastSpace := -1
+	content := false // spaces in the leading indentation are no break opportunity
+	linkStart := -1  // start of the hyperlink being measured
+	for i := 0; i < len(line); {
+		if n := escapeLen(line[i:]); n > 0 {
+			if open, ok := hyperlink(line[i : i+n]); ok {
+				linkStart = -1
+				if open && content {
+					linkStart = i
+				} else if open {
+					linkStart = 0 // only indentation before it, no point in breaking there
+				}
+			}
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		col = advance(col, r)
+		if col > width && i > 0 {
+			switch {
+			case linkStart >= 0 && lastSpace > 0 && lastSpace <= linkStart:
+				return lastSpace
+			case linkStart > 0:
+				return linkStart
+			case linkStart == 0:
+				// nothing to break before, the link overflows
+			case lastSpace > 0:
+				return lastSpace
+			default:
+				return i
+			}
+		}
+		if r == ' ' && content && linkStart < 0 {
+			lastSpace = i + size
+		}
+		content = content || (r != ' ' && r != '\t')
+		i += size
+	}
+	return len(line)
+}
+
+// columns returns the column reached after printing s from column col.
+func columns(s string, col int) int {
+	for i := 0; i < len(s); {
+		if n := escapeLen(s[i:]); n > 0 {
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		col = advance(col, r)
+		i += size
+	}
+	return col
+}
+
+func advance(col int, r rune) int {
+	if r == '\t' {
+		return (col/tabWidth + 1) * tabWidth
+	}
+	return col + 1
+}
+
+// hyperlink reports whether esc is an OSC-8 hyperlink escape, open being false for the one closing a link.
+func hyperlink(esc string) (open, ok bool) {
+	if !strings.HasPrefix(esc, "\x1b]8;") {
+		return false, false
+	}
+	params := strings.TrimPrefix(esc, "\x1b]8;")
+	semicolon := strings.IndexByte(params, ';')
+	if semicolon < 0 {
+		return false, false
+	}
+	url := strings.TrimRight(strings.TrimSuffix(params[semicolon+1:], "\x1b\\"), "\a")
+	return url != "", true
+}
+
+// escapeLen returns the length of the ANSI CSI or OSC escape sequence s starts with, 0 if it doesn't start with one.
+func escapeLen(s string) int {
+	if len(s) < 2 || s[0] != '\x1b' {
+		return 0
+	}
+	switch s[1] {
+	case '[':
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+	case ']':
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+	default:
+		return 0
+	}
+	return len(s)
+}
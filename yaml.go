@@ -0,0 +1,56 @@
+package traceUtils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NewStackTraceYAML - returns the stack trace as a YAML sequence of frame mappings. The emitter is hand-written to
+// avoid a YAML dependency, strings are double-quoted and multiline source (see WithSourceContext) is emitted
+// as a block scalar so it stays readable.
+func NewStackTraceYAML(opts ...StackTraceOption) ([]byte, error) {
+	t := newTrace(1, opts)
+	cfg := t.cfg
+	sources := newSourceCache(cfg)
+
+	if len(t.frames) == 0 {
+		return []byte("[]\n"), nil
+	}
+
+	// context lines go into a block scalar which brings its own indentation
+	contextCfg := cfg
+	contextCfg.ChunkIndentation = ""
+	contextCfg.SyntaxHighlight = false
+
+	var out strings.Builder
+	for i, f := range t.frames {
+		fmt.Fprintf(&out, "- frame: %d\n", i)
+		fmt.Fprintf(&out, "  func: %s\n", strconv.Quote(string(resolveFuncName(displayFunc(cfg, f.Func), cfg.ShortFuncNames))))
+		fmt.Fprintf(&out, "  file: %s\n", strconv.Quote(displayPath(cfg, f.File)))
+		if cfg.ShowLineNumbers {
+			fmt.Fprintf(&out, "  line: %d\n", f.Line)
+		}
+		if cfg.IncludePC && f.PC != 0 {
+			fmt.Fprintf(&out, "  pc: %s\n", strconv.Quote(formatPC(f.PC, cfg.PCFormat)))
+		}
+		if f.Inlined {
+			out.WriteString("  inlined: true\n")
+		}
+		if !cfg.IncludeSourceCode {
+			continue
+		}
+
+		lines := sources.lines(f.File)
+		if before, after := cfg.sourceContextLines(); (before > 0 || after > 0) && f.Line >= 1 && f.Line <= len(lines) {
+			// explicit indentation indicator, the first line may start with whitespace
+			out.WriteString("  source: |2-\n")
+			for _, line := range sourceContext(lines, f.Line, caretColumn(f, cfg), contextCfg) {
+				out.WriteString("    " + line + "\n")
+			}
+		} else {
+			fmt.Fprintf(&out, "  source: %s\n", strconv.Quote(string(source(lines, f.Line))))
+		}
+	}
+	return []byte(out.String()), nil
+}